@@ -0,0 +1,107 @@
+#version 430
+
+#include "common.glsl"
+
+//-----------------------------------------------------------------------------------//
+// data structs                                                                      //
+//-----------------------------------------------------------------------------------//
+out VertexOut {
+    vec3  position;
+    vec2  uv;
+    vec3  normal;
+    float texID;
+    vec3  tint;
+} o;
+
+//-----------------------------------------------------------------------------------//
+// uniforms                                                                          //
+//-----------------------------------------------------------------------------------//
+uniform int bladeCapacity;
+
+//-----------------------------------------------------------------------------------//
+// blade vertices                                                                    //
+//-----------------------------------------------------------------------------------//
+vec3 calcBladePos(Blade blade, float side, float e, float stiffness) {
+    // side is -1 for the left and 1 for the right edge, e is the relative height along the blade
+    vec3  root  = blade.root.xyz;
+    vec3  wind  = vec3(blade.wind.x, 0, blade.wind.y);
+    float r     = blade.right.w;
+    vec3  p     = root + side*blade.right.xyz + flatten(vec3(0, e*blade.root.w, 0), r, blade.wind.z);
+    p = displace(p, root, wind, e*e, r, stiffness);
+    return collide(p, root, e*e);
+}
+void makeBladeVertex(Blade blade, int vid) {
+    // the blade is a strip of segments, two vertices per level and superfluous vertices collapse at the tip
+    int   segments = int(blade.info.w);
+    int   k        = min(vid/2, segments);
+    int   side     = vid % 2;
+    float e        = float(k)/float(segments);
+    Species sp     = species[int(blade.info.y)];
+
+    // normal of the segment below the vertex or of the first segment
+    int   ks = max(k-1, 0);
+    float s0 = float(ks)/float(segments);
+    float s1 = float(ks+1)/float(segments);
+    vec3  p1 = calcBladePos(blade, -1, s1, sp.style.w);
+    vec3  p2 = calcBladePos(blade, -1, s0, sp.style.w);
+    vec3  p3 = calcBladePos(blade,  1, s1, sp.style.w);
+
+    vec3 pos = calcBladePos(blade, 2*side - 1, e, sp.style.w);
+    o.position  = pos;
+    o.uv        = vec2(side, 1-e);
+    o.normal    = calcNormal(p1, p2, p3);
+    o.texID     = blade.info.z;
+    o.tint      = sp.style.xyz;
+    gl_Position = P*V*M * vec4(pos, 1.0);
+}
+void makeCardVertex(Blade blade, int vid) {
+    // get tile radius in x and z
+    Tile  tile = tiles[int(blade.info.x)];
+    float size = blade.right.x;
+    vec2  tx   = vec2(size/2, 0);
+    vec2  tz   = vec2(0, size/2);
+
+    // four corners of the tile, superfluous vertices collapse at the last corner
+    vec2 p1 = tile.pos - tx + tz;
+    vec2 p2 = tile.pos - tx - tz;
+    vec2 p3 = tile.pos + tx + tz;
+    vec2 p4 = tile.pos + tx - tz;
+    float lift = blade.root.w;
+    vec3 v1 = vec3(p1.x, calcRootHeight(tile.tri1, p1.x, p1.y).y + lift, p1.y);
+    vec3 v2 = vec3(p2.x, calcRootHeight(tile.tri1, p2.x, p2.y).y + lift, p2.y);
+    vec3 v3 = vec3(p3.x, calcRootHeight(tile.tri1, p3.x, p3.y).y + lift, p3.y);
+    vec3 v4 = vec3(p4.x, calcRootHeight(tile.tri2, p4.x, p4.y).y + lift, p4.y);
+
+    // pick corner
+    int  v   = min(vid, 3);
+    vec3 pos = v4;
+    vec2 uv  = vec2(1, 1);
+    if     (v == 0) { pos = v1; uv = vec2(0, 0); }
+    else if(v == 1) { pos = v2; uv = vec2(0, 1); }
+    else if(v == 2) { pos = v3; uv = vec2(1, 0); }
+
+    o.position  = pos;
+    o.uv        = uv;
+    o.normal    = calcNormal(v1, v2, v3);
+    o.texID     = 0.0;
+    o.tint      = vec3(1.0);
+    gl_Position = P*V*M * vec4(pos, 1.0);
+}
+
+void main() {
+    // blades that didn't fit into the blade buffer are not drawn
+    if(gl_InstanceID >= bladeCapacity) {
+        o.position  = vec3(0);
+        o.uv        = vec2(0);
+        o.normal    = vec3(0, 1, 0);
+        o.texID     = 0.0;
+        o.tint      = vec3(1.0);
+        gl_Position = vec4(2.0, 2.0, 2.0, 1.0);
+        return;
+    }
+
+    // grass cards have no segments
+    Blade blade = blades[gl_InstanceID];
+    if(blade.info.w == 0.0) { makeCardVertex(blade, gl_VertexID); }
+    else                    { makeBladeVertex(blade, gl_VertexID); }
+}
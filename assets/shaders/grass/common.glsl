@@ -0,0 +1,330 @@
+//-----------------------------------------------------------------------------------//
+// shared by cull.comp, blade.vert and grass.geom, see loadFile in shaderprogram.go  //
+//-----------------------------------------------------------------------------------//
+
+//-----------------------------------------------------------------------------------//
+// constants                                                                         //
+//-----------------------------------------------------------------------------------//
+const float TWOPI  = 6.28318530717;
+const float PI     = 3.14159265358;
+const float HALFPI = 1.57079632679;
+
+// materials of the tiles
+const int MEADOW = 0;
+const int DIRT   = 1;
+const int PATH   = 2;
+const int ROCK   = 3;
+
+// species of the grass
+const int MAXSPECIES  = 8;
+const int MAXSEGMENTS = 8;
+
+// colliders pushing the grass
+const int MAXCOLLIDERS = 32;
+
+// maximum angle of trampled grass blades to the up vector
+const float MAXTRAMPLEANGLE = 1.39626340159;
+
+//-----------------------------------------------------------------------------------//
+// data structs                                                                      //
+//-----------------------------------------------------------------------------------//
+struct Tile {
+    vec4  tri1;
+    vec4  tri2;
+    vec2  pos;
+    float lod;
+    float padding;
+};
+struct Species {
+    vec4 size;     // min height, max height, min width, max width
+    vec4 style;    // tint, stiffness
+    vec4 textures; // up to 4 texture IDs
+    vec4 counts;   // segment count, texture count
+};
+struct Blade {
+    vec4 root;     // root position, height or lift of a grass card
+    vec4 right;    // right vector scaled by the width or size of a grass card, random number
+    vec4 wind;     // wind in x and z, trampling
+    vec4 info;     // tile ID, species, texture ID, segment count or 0 for a grass card
+};
+
+//-----------------------------------------------------------------------------------//
+// buffers                                                                           //
+//-----------------------------------------------------------------------------------//
+layout(std430, binding = 0) buffer TileBuffer     { Tile tiles[]; };
+layout(std430, binding = 1) buffer Velocityfield  { vec4 velocity[]; };
+layout(std430, binding = 3) buffer SpeciesBuffer  { Species species[]; };
+layout(std430, binding = 4) buffer MixBuffer      { float mixes[]; };
+layout(std430, binding = 5) buffer ColliderBuffer { vec4 colliders[]; };
+layout(std430, binding = 6) buffer TrampleField   { float trample[]; };
+layout(std430, binding = 7) buffer CutBuffer      { float cuts[]; };
+layout(std430, binding = 9) buffer BladeBuffer    { Blade blades[]; };
+
+//-----------------------------------------------------------------------------------//
+// uniforms                                                                          //
+//-----------------------------------------------------------------------------------//
+uniform mat4  M, V, P;
+uniform vec3  cameraPos;
+uniform float grassHeight;
+uniform int   bladeCount;
+uniform int   speciesCount;
+uniform int   colliderCount;
+uniform float tilesize;
+uniform float t;
+uniform float d2;
+uniform int   radius;
+uniform int   trampleDim;
+uniform int   trampleCenterX;
+uniform int   trampleCenterZ;
+uniform float trampleCellSize;
+
+//-----------------------------------------------------------------------------------//
+// get tile                                                                          //
+//-----------------------------------------------------------------------------------//
+float getTileSize(Tile tile) {
+    // coarser tiles span 2^lod tiles of the finest level
+    return tilesize * exp2(tile.lod);
+}
+int getTileMaterial(Tile tile) {
+    // the integer part of the padding is the material, submerged tiles are offset by the number of materials
+    return int(floor(tile.padding)) % 4;
+}
+bool isTileSubmerged(Tile tile) {
+    return int(floor(tile.padding)) % 8 >= 4;
+}
+int getTileMix(Tile tile) {
+    // every species mix offsets the material by twice the number of materials
+    return int(floor(tile.padding)) / 8;
+}
+float getTileDensity(Tile tile) {
+    // the fractional part of the padding is the grass density
+    return fract(tile.padding);
+}
+
+//-----------------------------------------------------------------------------------//
+// randomization                                                                     //
+//-----------------------------------------------------------------------------------//
+float rand(Tile tile, vec2 pos) {
+    float f = tile.pos.x*tile.pos.y;
+    return sin(f*HALFPI*fract(pos.x) + f*HALFPI*fract(pos.y));
+}
+float range(float min, float max, float r) {
+    return (max - min)*r + min;
+}
+float time(float freq, float phase) {
+    return sin(phase + mod(t,freq)/(freq*0.5) * PI);
+}
+
+//-----------------------------------------------------------------------------------//
+// get the root positions                                                            //
+//-----------------------------------------------------------------------------------//
+vec3 calcRootHeight(vec4 plane, float x, float z) {
+    float y = -(plane.w + plane.x*x + plane.z*z) / plane.y;
+    return vec3(0, y, 0);
+}
+vec3 getRootLocalPos(vec2 pos, float r) {
+    pos.x = mod(pos.x + r, 1.0) - 0.5;
+    pos.y = mod(pos.y + r, 1.0) - 0.5;
+    return vec3(pos.x, 0.0, pos.y);
+}
+vec3 calcRootWorldPos(Tile tile, vec3 local) {
+    // get grass local and world coordinates
+    vec3 rPos = local*getTileSize(tile);
+    vec3 pos = vec3(tile.pos.x, 0, tile.pos.y) + rPos;
+
+    // add root height
+    vec4 plane = tile.tri2;
+    if (rPos.x < rPos.z) { plane = tile.tri1; }
+    return pos + calcRootHeight(plane, pos.x, pos.z);
+}
+vec3 extractCameraRight() {
+    return vec3(V[0][0], V[1][0], V[2][0]);
+}
+
+//-----------------------------------------------------------------------------------//
+// calculating LOD                                                                   //
+//-----------------------------------------------------------------------------------//
+float dist(vec3 pos) {
+    return length(pos - cameraPos);
+}
+int   calcLOD(vec3 pos) {
+    float x = dist(pos) / d2;
+    float off = 0.5;
+    return int(4*(off*off)/((x+off)*(x+off)));
+}
+float calcLODDist(vec3 pos) {
+    float x = 5 * dist(pos) / d2;
+    return pow(1.17, -(x*x));
+}
+float calcLODBladeWidth(vec3 pos, Species s, float r) {
+    float dist = (1-calcLODDist(pos))*4 + 1.0;
+    return range(s.size.z, s.size.w, r)*dist;
+}
+int   calcLODBladeCount(vec3 pos) {
+    int lod = calcLOD(pos);
+    int count = bladeCount;
+    if     (lod == 2) count = int(0.95*bladeCount);
+    else if(lod == 1) count = int(0.85*bladeCount);
+    else if(lod == 0) count = 1;
+    return count;
+}
+int   calcLODSegments(int lod, Species sp) {
+    if     (lod == 3) return int(sp.counts.x);
+    else if(lod == 2) return (int(sp.counts.x) + 1)/2;
+    return 1;
+}
+
+//-----------------------------------------------------------------------------------//
+// shaping the grass blades                                                          //
+//-----------------------------------------------------------------------------------//
+vec3 calcNormal(vec3 v1, vec3 v2, vec3 v3) {
+    vec3 d1 = v1 - v2;
+    vec3 d2 = v3 - v2;
+    return normalize(cross(d1, d2));
+}
+vec3 displace(vec3 pos, vec3 root, vec3 wind, float coeff, float r, float stiffness) {
+    // calc wind influence, stiff grass bends less
+    vec3 force = range(0.4, 0.5, r)*wind*(1 - stiffness);
+
+    // calc grass bending
+    vec3 npos = pos;
+    npos.xz += force.xz*coeff;
+    npos.y  -= length(force)*sqrt(coeff);
+
+    // calc grass oszillation
+    vec3 dir = normalize(force);
+    float strength = length(force);
+    if(strength == 0.0) { dir = vec3(1, 0, 0); }
+    float bend = 1 - (grassHeight - (npos.y - root.y)) / grassHeight;
+    bend = 0.4*strength * bend*bend * time(60 + r*20, r*PI);
+    npos.xz += bend*dir.xz*coeff;
+    npos.y  -= bend*sqrt(coeff);
+
+    return npos;
+}
+vec3 collide(vec3 pos, vec3 root, float coeff) {
+    // colliders are capsules around the segment from start to end, each stored as 2 vec4
+    for(int c = 0; c < min(colliderCount, MAXCOLLIDERS); c++) {
+        vec3  a      = colliders[2*c].xyz;
+        float radius = colliders[2*c].w;
+        vec3  b      = colliders[2*c+1].xyz;
+
+        // closest point on the segment
+        vec3  ab = b - a;
+        float l  = dot(ab, ab);
+        float s  = (l > 1e-6) ? clamp(dot(pos - a, ab) / l, 0, 1) : 0.0;
+        vec3  d  = pos - (a + s*ab);
+        float dist = length(d);
+        if(dist >= radius) { continue; }
+
+        // push the blade sideways out of the collider, the root stays in place
+        vec2 dir = d.xz;
+        if(length(dir) < 1e-4) { dir = pos.xz - root.xz; }
+        if(length(dir) < 1e-4) { dir = vec2(1, 0); }
+        float push = (radius - dist)*sqrt(coeff);
+        pos.xz += normalize(dir)*push;
+        pos.y   = max(pos.y - 0.5*push, root.y);
+    }
+    return pos;
+}
+vec3 flatten(vec3 up, float r, float trampled) {
+    // trampled blades are laid down in a random direction around their root
+    float angle = trampled*MAXTRAMPLEANGLE;
+    vec2  dir   = vec2(cos(r*TWOPI*7), sin(r*TWOPI*7));
+    float h     = up.y;
+    return vec3(dir.x*sin(angle)*h, cos(angle)*h, dir.y*sin(angle)*h);
+}
+
+//-----------------------------------------------------------------------------------//
+// calculate texture                                                                 //
+//-----------------------------------------------------------------------------------//
+int getSpecies(int mix, float r) {
+    // pick the species by the cumulative weights of the mix
+    float rs = fract((r + 1.0)*17.0);
+    for(int s = 0; s < speciesCount-1; s++) {
+        if(rs < mixes[mix*MAXSPECIES + s]) { return s; }
+    }
+    return speciesCount-1;
+}
+int getSpeciesTextureID(Species sp, int material, float r) {
+    // dirt and rock only grow a single kind of sparse grass
+    int count = max(int(sp.counts.y), 1);
+    int idx   = min(int(fract((r + 1.0)*5.3)*count), count-1);
+    if     (material == DIRT) idx = 0;
+    else if(material == ROCK) idx = count-1;
+    return int(sp.textures[idx]);
+}
+float getMaterialHeight(int material) {
+    if     (material == DIRT) return 0.6;
+    else if(material == ROCK) return 0.4;
+    return 1.0;
+}
+
+//-----------------------------------------------------------------------------------//
+// calculate wind                                                                    //
+//-----------------------------------------------------------------------------------//
+vec2 getWindAt(int x, int z) {
+    vec2 wind = vec2(0, 0);
+    if(abs(x) <= radius && abs(z) <= radius) {
+        int dim = 2*radius + 1;
+        int idx = (z+radius)*dim + (x+radius);
+        vec4 vel = velocity[idx];
+        wind = vec2(vel.x, vel.y);
+    }
+    return wind;
+}
+vec2 calcWind(Tile tile, vec3 local) {
+    // extract tile positions of the current tile and camera
+    int tx = int(tile.pos.x / tilesize);
+    int tz = int(tile.pos.y / tilesize);
+    int cx = int(cameraPos.x / tilesize);
+    int cz = int(cameraPos.z / tilesize);
+    if(tile.pos.x < 0) { tx -= 1; }
+    if(tile.pos.y < 0) { tz -= 1; }
+    if(cameraPos.x < 0) { cx -= 1; }
+    if(cameraPos.z < 0) { cz -= 1; }
+
+    // tile relative to camera pos
+    int rx = tx - cx;
+    int rz = tz - cz;
+
+    // get interpolation alpha from relative grass position
+    int   dx = int(sign(local.x));
+    int   dz = int(sign(local.z));
+    float ax = abs(local.x);
+    float az = abs(local.z);
+
+    // make wind by bilear interpolation
+    vec2 w0  = getWindAt(rx,    rz   );
+    vec2 w0x = getWindAt(rx+dx, rz   );
+    vec2 w1  = getWindAt(rx,    rz+dz);
+    vec2 w1x = getWindAt(rx+dx, rz+dz);
+    w0 = mix(w0, w0x, ax);
+    w1 = mix(w1, w1x, ax);
+    return mix(w0, w1, az);
+}
+
+//-----------------------------------------------------------------------------------//
+// trampling                                                                         //
+//-----------------------------------------------------------------------------------//
+float getTrampleAt(int x, int z) {
+    float value = 0.0;
+    if(x >= 0 && x < trampleDim && z >= 0 && z < trampleDim) {
+        value = trample[z*trampleDim + x];
+    }
+    return value;
+}
+float calcTrample(vec3 root) {
+    // cell of the trample map relative to its lower corner, values are stored at the cell centers
+    vec2  p  = root.xz/trampleCellSize - 0.5;
+    vec2  f  = floor(p);
+    vec2  a  = p - f;
+    int   r  = trampleDim/2;
+    int   x  = int(f.x) - trampleCenterX + r;
+    int   z  = int(f.y) - trampleCenterZ + r;
+
+    // bilinear interpolation of the 4 closest cells
+    float t0 = mix(getTrampleAt(x, z  ), getTrampleAt(x+1, z  ), a.x);
+    float t1 = mix(getTrampleAt(x, z+1), getTrampleAt(x+1, z+1), a.x);
+    return mix(t0, t1, a.y);
+}
@@ -5,6 +5,7 @@ in VertexOut {
     vec2  uv;
     vec3  normal;
     float texID;
+    vec3  tint;
 } i;
 
 // phong shader uniforms
@@ -16,35 +17,43 @@ uniform float diffuseIntensity;
 uniform float d1;
 uniform float d2;
 
-// textures
+// textures, the grass card of far away tiles has the ID 0 and the textures of the grass species the IDs 1 to 7
 layout(binding = 0) uniform sampler2D grassAlpha;
-layout(binding = 1) uniform sampler2D grassDiffuse0;
+layout(binding = 1) uniform sampler2D grassCard;
 layout(binding = 2) uniform sampler2D grassDiffuse1;
 layout(binding = 3) uniform sampler2D grassDiffuse2;
 layout(binding = 4) uniform sampler2D grassDiffuse3;
+layout(binding = 5) uniform sampler2D grassDiffuse4;
+layout(binding = 6) uniform sampler2D grassDiffuse5;
+layout(binding = 7) uniform sampler2D grassDiffuse6;
+layout(binding = 8) uniform sampler2D grassDiffuse7;
 
 layout(location = 0) out vec3 fragColor;
 
+vec3 getDiffuse(int texID, vec2 uv) {
+    if     (texID == 1) return texture(grassDiffuse1, uv).xyz;
+    else if(texID == 2) return texture(grassDiffuse2, uv).xyz;
+    else if(texID == 3) return texture(grassDiffuse3, uv).xyz;
+    else if(texID == 4) return texture(grassDiffuse4, uv).xyz;
+    else if(texID == 5) return texture(grassDiffuse5, uv).xyz;
+    else if(texID == 6) return texture(grassDiffuse6, uv).xyz;
+    else if(texID == 7) return texture(grassDiffuse7, uv).xyz;
+    return texture(grassCard, uv).xyz;
+}
+
 void main() {
+    int texID = int(i.texID + 0.5);
+
     // discard transparent pixels
-    if(texture(grassAlpha, i.uv).x < 0.5 && i.texID != 0) {
+    if(texture(grassAlpha, i.uv).x < 0.5 && texID != 0) {
         discard;
     }
 
-    // select right grass texture
-    vec3 grassColor = texture(grassDiffuse0, i.uv).xyz;
+    // select right grass texture and tint it by the species
+    vec3 grassColor = getDiffuse(texID, i.uv) * i.tint;
     float specularFactor = 0.1;
     float mixFactor = 1.0;
-    if (i.texID == 3) {
-        grassColor = texture(grassDiffuse3, i.uv).xyz;
-        specularFactor = clamp(0.3 - i.uv.y, 0.0, 1.0)*3;
-        mixFactor = 1-i.uv.y;
-    } else if (i.texID == 2) {
-        grassColor = texture(grassDiffuse2, i.uv).xyz;
-        specularFactor = clamp(0.3 - i.uv.y, 0.0, 1.0)*3;
-        mixFactor = 1-i.uv.y;
-    } else if (i.texID == 1) {
-        grassColor = texture(grassDiffuse1, i.uv).xyz;
+    if (texID != 0) {
         specularFactor = clamp(0.3 - i.uv.y, 0.0, 1.0)*3;
         mixFactor = 1-i.uv.y;
     }
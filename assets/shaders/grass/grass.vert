@@ -8,9 +8,16 @@ out VertexIn {
     int vid;
 } o;
 
+layout(std430, binding = 2) buffer VisibleBuffer { int visible[]; };
+
+uniform int tilesPerChunk;
+
 void main() {
+    // look up the tile in the slot of the visible chunk
+    int slot = visible[gl_InstanceID / tilesPerChunk];
+
     gl_Position = vec4(0.0, 0.0, 0.0, 1.0);
     o.pos = position;
-    o.id  = gl_InstanceID;
+    o.id  = slot*tilesPerChunk + gl_InstanceID % tilesPerChunk;
     o.vid = gl_VertexID;
 }
\ No newline at end of file
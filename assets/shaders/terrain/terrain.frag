@@ -3,6 +3,7 @@
 in VertexOut {
     vec3 position;
     vec3 normal;
+    vec4 materials;
 } i;
 
 // phong shader uniforms
@@ -15,8 +16,24 @@ uniform float diffuseIntensity;
 uniform float d1;
 uniform float d2;
 
+// ground colors of the materials meadow, dirt, path and rock
+const vec3 meadowColor = vec3(0.0, 0.0, 0.0);
+const vec3 dirtColor   = vec3(0.25, 0.17, 0.09);
+const vec3 pathColor   = vec3(0.55, 0.47, 0.33);
+const vec3 rockColor   = vec3(0.35, 0.35, 0.35);
+
 layout(location = 0) out vec3 fragColor;
 
 void main() {
-    fragColor = vec3(0.0, 0.0, 0.0);
-}
\ No newline at end of file
+    // blend the ground colors by the material weights
+    vec4 w = i.materials / max(dot(i.materials, vec4(1.0)), 0.0001);
+    vec3 groundColor = w.x*meadowColor + w.y*dirtColor + w.z*pathColor + w.w*rockColor;
+
+    // diffuse lighting
+    vec3  n       = normalize(i.normal);
+    if (n.y < 0.0) { n = -n; }
+    float diffuse = max(dot(n, normalize(lightDir)), 0.0);
+    float light   = ambientIntensity + diffuseIntensity*diffuse;
+
+    fragColor = groundColor * lightColor * light;
+}
@@ -1,12 +1,26 @@
 #version 430
 
-layout (location = 0) in vec3 position;
-
 out VertexIn {
     int id;
 } o;
+struct Tile {
+    vec4  tri1;
+    vec4  tri2;
+    vec2  pos;
+    float lod;
+    float padding;
+};
+
+layout(std430, binding = 0) buffer TileBuffer    { Tile tiles[]; };
+layout(std430, binding = 2) buffer VisibleBuffer { int visible[]; };
+
+uniform int tilesPerChunk;
 
 void main() {
-    gl_Position = vec4(position, 1.0);
-    o.id = gl_VertexID;
+    // look up the tile in the slot of the visible chunk
+    int slot = visible[gl_InstanceID / tilesPerChunk];
+    int id   = slot*tilesPerChunk + gl_InstanceID % tilesPerChunk;
+
+    gl_Position = vec4(tiles[id].pos.x, 0.0, tiles[id].pos.y, 1.0);
+    o.id = id;
 }
\ No newline at end of file
@@ -0,0 +1,99 @@
+#version 430
+
+in VertexOut {
+    vec3 position;
+} i;
+
+// textures
+layout(binding = 0) uniform sampler2D   sceneColor;
+layout(binding = 1) uniform sampler2D   sceneDepth;
+layout(binding = 2) uniform samplerCube skyMap;
+
+uniform mat4  V, P;
+uniform vec3  cameraPos;
+uniform float zNear;
+uniform float zFar;
+uniform vec2  resolution;
+uniform float t;
+
+// water colors
+const vec3  shallowColor = vec3(0.10, 0.35, 0.35);
+const vec3  deepColor    = vec3(0.02, 0.08, 0.15);
+const float absorption   = 0.02;
+// screen space reflection
+const int   reflectionSteps    = 32;
+const float reflectionStepSize = 20.0;
+
+layout(location = 0) out vec3 fragColor;
+
+float linearizeDepth(float depth) {
+    float z = depth * 2.0 - 1.0;
+    return (2.0 * zNear * zFar) / (zFar + zNear - z * (zFar - zNear));
+}
+
+// sum of several sine waves travelling in different directions
+vec3 calcWaveNormal(vec2 pos) {
+    vec2 d1 = vec2( 1.0,  0.3);
+    vec2 d2 = vec2(-0.4,  1.0);
+    vec2 d3 = vec2( 0.7, -0.8);
+    float time = t * 0.05;
+    float dx = 0.0;
+    float dz = 0.0;
+    float g1 = cos(dot(d1, pos)*0.020 + time*1.0) * 0.020;
+    float g2 = cos(dot(d2, pos)*0.035 + time*1.3) * 0.015;
+    float g3 = cos(dot(d3, pos)*0.060 + time*1.7) * 0.010;
+    dx += g1*d1.x + g2*d2.x + g3*d3.x;
+    dz += g1*d1.y + g2*d2.y + g3*d3.y;
+    return normalize(vec3(-dx, 1.0, -dz));
+}
+
+// marches the reflected ray in view space and compares it with the depth of the scene
+bool traceReflection(vec3 pos, vec3 dir, out vec2 hituv) {
+    for (int s = 1; s <= reflectionSteps; s++) {
+        vec3 p = pos + dir * reflectionStepSize * float(s*s) * 0.25;
+        vec4 clip = P*V * vec4(p, 1.0);
+        if (clip.w <= 0.0) { return false; }
+        vec3 ndc = clip.xyz / clip.w;
+        vec2 uv  = ndc.xy * 0.5 + 0.5;
+        if (uv.x < 0.0 || uv.x > 1.0 || uv.y < 0.0 || uv.y > 1.0) { return false; }
+        float scene = linearizeDepth(texture(sceneDepth, uv).r);
+        float ray   = linearizeDepth(ndc.z * 0.5 + 0.5);
+        if (ray > scene && ray - scene < reflectionStepSize * float(s)) {
+            hituv = uv;
+            return true;
+        }
+    }
+    return false;
+}
+
+void main() {
+    vec3  normal  = calcWaveNormal(i.position.xz);
+    vec3  viewDir = normalize(i.position - cameraPos);
+    vec2  uv      = gl_FragCoord.xy / resolution;
+
+    // depth of the water along the view ray
+    float waterDist = linearizeDepth(gl_FragCoord.z);
+    float sceneDist = linearizeDepth(texture(sceneDepth, uv).r);
+    float depth     = max(sceneDist - waterDist, 0.0);
+
+    // refraction of the ground below the water
+    vec2  offset     = normal.xz * 0.02 * clamp(depth / 50.0, 0.0, 1.0);
+    vec3  ground     = texture(sceneColor, uv + offset).xyz;
+    float visibility = exp(-depth * absorption);
+    vec3  waterColor = mix(deepColor, shallowColor, visibility);
+    vec3  refraction = mix(waterColor, ground, visibility);
+
+    // reflection of the terrain or the skybox
+    vec3 reflected  = reflect(viewDir, normal);
+    vec3 reflection = texture(skyMap, reflected).xyz;
+    vec2 hituv;
+    if (traceReflection(i.position, reflected, hituv)) {
+        reflection = texture(sceneColor, hituv).xyz;
+    }
+
+    // fresnel decides how much is reflected
+    float cosTheta = max(dot(-viewDir, normal), 0.0);
+    float fresnel  = 0.02 + 0.98 * pow(1.0 - cosTheta, 5.0);
+
+    fragColor = mix(refraction, reflection, fresnel);
+}
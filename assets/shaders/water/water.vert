@@ -0,0 +1,17 @@
+#version 430
+
+out VertexIn {
+    float size;
+} o;
+
+layout(std430, binding = 0) buffer WaterBuffer { vec4 chunks[]; };
+
+uniform float seaLevel;
+
+void main() {
+    // center and size of the submerged chunk
+    vec4 chunk = chunks[gl_InstanceID];
+
+    gl_Position = vec4(chunk.x, seaLevel, chunk.y, 1.0);
+    o.size = chunk.z;
+}
@@ -0,0 +1,75 @@
+// Command erode applies hydraulic and thermal erosion to a heightmap.
+// Heightmaps of integer formats are written as 16 bit grayscale PNG,
+// while floating point heightmaps are written as .r32 or .pfm to keep heights outside of 0 to 1.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/scene"
+)
+
+func main() {
+	// parse arguments
+	defaults := scene.MakeErosionSettings(1337, 200000)
+	in := flag.String("in", "./assets/images/textures/heightmap.png", "path of the heightmap to erode")
+	out := flag.String("out", "", "path of the eroded heightmap, defaults to heightmap-eroded with the extension of the input")
+	seed := flag.Int64("seed", defaults.Seed, "seed of the random droplet positions")
+	droplets := flag.Int("droplets", defaults.Droplets, "number of droplets of the hydraulic erosion")
+	inertia := flag.Float64("inertia", float64(defaults.Inertia), "how much a droplet keeps its direction")
+	capacity := flag.Float64("capacity", float64(defaults.Capacity), "sediment capacity factor of a droplet")
+	erosionrate := flag.Float64("erosion", float64(defaults.ErosionRate), "fraction of the free capacity that is eroded per step")
+	depositionrate := flag.Float64("deposition", float64(defaults.DepositionRate), "fraction of the exceeding sediment that is deposited per step")
+	evaporation := flag.Float64("evaporation", float64(defaults.Evaporation), "fraction of water a droplet loses per step")
+	lifetime := flag.Int("lifetime", defaults.Lifetime, "maximum number of steps of a droplet")
+	radius := flag.Int("radius", defaults.Radius, "erosion radius of a droplet in pixels")
+	thermal := flag.Int("thermal", defaults.ThermalIterations, "number of thermal erosion iterations")
+	talus := flag.Float64("talus", float64(defaults.Talus), "maximum normalized height difference between neighbouring pixels")
+	flag.Parse()
+
+	// load heightmap
+	heightmap, err := scene.MakeHeightmap(*in, 1.0, scene.NEAREST)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// floating point heights can only be written as .r32 or .pfm
+	if *out == "" {
+		*out = "heightmap-eroded.png"
+		if !heightmap.IsNormalized() {
+			*out = "heightmap-eroded" + filepath.Ext(*in)
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(*out))
+	if !heightmap.IsNormalized() && ext != ".r32" && ext != ".pfm" {
+		fmt.Fprintf(os.Stderr, "%v has floating point heights, use the extension .r32 or .pfm for the output\n", *in)
+		os.Exit(1)
+	}
+
+	// erode
+	settings := defaults
+	settings.Seed = *seed
+	settings.Droplets = *droplets
+	settings.Inertia = float32(*inertia)
+	settings.Capacity = float32(*capacity)
+	settings.ErosionRate = float32(*erosionrate)
+	settings.DepositionRate = float32(*depositionrate)
+	settings.Evaporation = float32(*evaporation)
+	settings.Lifetime = *lifetime
+	settings.Radius = *radius
+	settings.ThermalIterations = *thermal
+	settings.Talus = float32(*talus)
+	heightmap.Erode(settings)
+
+	// write result
+	if err := heightmap.Save(*out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Eroded %v with %v droplets and %v thermal iterations into %v\n", *in, settings.Droplets, settings.ThermalIterations, *out)
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"runtime"
 	"strconv"
+	"time"
 
+	"github.com/go-gl/glfw/v3.2/glfw"
 	"github.com/go-gl/mathgl/mgl32"
 
 	"github.com/adrianderstroff/realtime-grass/pkg/engine"
@@ -21,16 +25,49 @@ var (
 	width         int32   = 800
 	height        int32   = 600
 	terrainheight float32 = 300.0
+	sealevel      float32 = 0.0
 	viewdist      float32 = 5000.0
+	lodlevels     int32   = 1
 	windradius    int32   = 30
 	windinfluence float32 = 4.0
+	regrowth      float32 = 0.05
 	bladecount    int     = 100
 	grassHeight   float32 = 50.0
+	walkspeed     float32 = 200.0
+	flyspeed      float32 = 600.0
+	jumpspeed     float32 = 400.0
+	gravity       float32 = 980.0
+	maxwalkslope  float32 = 40.0
+	stepsmoothing float32 = 10.0
+	playerradius  float32 = 30.0
+	usenoise      bool    = false
+	atlaspath     string  = ""
+	seed          int64   = 1337
+	erosiondrops  int     = 0
+	seamlessband  int32   = 0
+	wrapmode              = scene.REPEAT
+	splatpath     string  = ""
+	usegrassrules bool    = false
+	grassmaxslope float32 = 45.0
+	grassaltitude float32 = 0.9
+	exportpath    string  = "terrain.gltf"
+	exportformat          = scene.GLTF
+	exportgrass   bool    = false
+	mowradius     float32 = 60.0
+	mowheight     float32 = 0.3
+	cutpath       string  = "grasscuts.json"
+	brushradius   float32 = 150.0
+	sculptpath    string  = "sculpted.png"
 )
 
 func main() {
 	runtime.LockOSThread()
 
+	// all randomness is derived from the seed, thus the same seed always yields the same world
+	flag.Int64Var(&seed, "seed", seed, "seed of all random features of the world")
+	flag.Parse()
+	fmt.Printf("Using seed %v, run with -seed %v to reproduce\n", seed, seed)
+
 	// setup opengl
 	windowManager, err := engine.NewWindowManager("Grass", int(width), int(height))
 	if err != nil {
@@ -40,11 +77,89 @@ func main() {
 	windowManager.SetClearColor(0, 0, 0)
 	defer windowManager.Close()
 
+	// make height source
+	var source scene.HeightSource
+	if usenoise {
+		noise := scene.MakeNoiseHeightmap(seed, scene.SIMPLEX, 6, 4.0, 2.0, 0.5, 0.2, terrainheight)
+		source = &noise
+	} else if atlaspath != "" {
+		atlas, err := scene.MakeHeightmapAtlas(atlaspath, scene.BICUBIC)
+		if err != nil {
+			panic(err)
+		}
+		source = &atlas
+	} else {
+		heightmap, err := scene.MakeHeightmap(TEX_PATH+"heightmap.png", terrainheight, scene.BICUBIC)
+		if err != nil {
+			panic(err)
+		}
+		// optionally erode the heightmap before any chunk is built
+		if erosiondrops > 0 {
+			heightmap.Erode(scene.MakeErosionSettings(seed, erosiondrops))
+		}
+		// hide the seams where the heightmap repeats
+		if wrapmode == scene.REPEAT && seamlessband > 0 {
+			heightmap.MakeSeamless(seamlessband)
+		}
+		source = &heightmap
+	}
+
+	// make optional splat map
+	var splatmap *scene.Splatmap
+	if splatpath != "" {
+		splat, err := scene.MakeSplatmap(splatpath)
+		if err != nil {
+			panic(err)
+		}
+		splatmap = &splat
+	}
+
+	// make optional grass density rules, no grass on cliffs and peaks and patches of grass in the meadows
+	var rules *scene.GrassRules
+	if usegrassrules {
+		grassrules := scene.MakeGrassRules(
+			scene.MakeSlopeRule(mgl32.DegToRad(grassmaxslope), mgl32.DegToRad(10.0)),
+			scene.MakeAltitudeRule(0.0, grassaltitude*terrainheight, 0.05*terrainheight),
+			scene.MakeNoiseRule(seed, 2000.0, 0.25, 0.1),
+		)
+		rules = &grassrules
+	}
+
+	// make grass species
+	species := []scene.GrassSpecies{
+		scene.MakeDefaultGrassSpecies(),
+		scene.MakeReedSpecies(),
+		scene.MakeWheatSpecies(),
+		scene.MakeLawnSpecies(),
+	}
+
 	// make terrain
-	terrain, err := scene.MakeTerrain(SHADER_PATH, TEX_PATH, 5000.0, 10, 10, terrainheight, bladecount, grassHeight, viewdist, windradius, windinfluence)
+	settings := scene.MakeTerrainSettings(seed)
+	settings.Splatmap = splatmap
+	settings.Rules = rules
+	settings.Species = species
+	settings.SeaLevel = sealevel
+	settings.LodLevels = lodlevels
+	settings.TrampleRegrowth = regrowth
+	terrain, err := scene.MakeTerrain(SHADER_PATH, TEX_PATH, source, wrapmode, 5000.0, 10, 10, terrainheight, bladecount, grassHeight, viewdist, windradius, windinfluence, settings)
 	if err != nil {
 		panic(err)
 	}
+	defer terrain.Delete()
+
+	// the blue channel of the splat map picks the mix of each tile, without a splat map every tile is a meadow
+	// mostly covered by meadow grass with some reeds and wheat, the other mixes are wheat fields, lawns and reed beds
+	mixes := [][]float32{
+		{0.8, 0.05, 0.05, 0.1},
+		{0.2, 0.0, 0.8, 0.0},
+		{0.0, 0.0, 0.0, 1.0},
+		{0.1, 0.9, 0.0, 0.0},
+	}
+	for mix, weights := range mixes {
+		if err := terrain.SetGrassMix(int32(mix), weights...); err != nil {
+			panic(err)
+		}
+	}
 
 	// make skybox
 	sky, err := scene.MakeSky(SHADER_PATH, SKY_PATH)
@@ -52,10 +167,123 @@ func main() {
 		panic(err)
 	}
 
+	// make water
+	water, err := scene.MakeWater(SHADER_PATH, sealevel, width, height)
+	if err != nil {
+		panic(err)
+	}
+	defer water.Delete()
+
 	// set camera
 	camera := engine.MakeCameraFPS(int(width), int(height), mgl32.Vec3{0.0, 100.0, 0.0}, 6.0, 45.0, 0.1, viewdist)
-	windowManager.AddInteractable(&camera)
+
+	// walk over the terrain with the camera at grass height, F toggles the fly mode
+	controller := scene.MakeCharacterController(&camera, &terrain, grassHeight, walkspeed, flyspeed, jumpspeed, gravity, mgl32.DegToRad(maxwalkslope), stepsmoothing)
+	windowManager.AddInteractable(&controller)
+
+	// export the loaded terrain when pressing F5
+	windowManager.AddKeyPressHandler(func(key, action, mods int) bool {
+		if key == int(glfw.KeyF5) && action == int(glfw.Press) {
+			if err := terrain.Export(exportpath, exportformat, exportgrass); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Exported terrain to " + exportpath)
+			}
+			return true
+		}
+		return false
+	})
+
+	// mow the grass around the player while M is held, F6 saves and F7 loads the mowed grass
+	mowing := false
+	windowManager.AddKeyPressHandler(func(key, action, mods int) bool {
+		switch {
+		case key == int(glfw.KeyM) && action != int(glfw.Repeat):
+			mowing = action == int(glfw.Press)
+		case key == int(glfw.KeyF6) && action == int(glfw.Press):
+			if err := terrain.SaveGrassCuts(cutpath); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Saved mowed grass to " + cutpath)
+			}
+		case key == int(glfw.KeyF7) && action == int(glfw.Press):
+			if err := terrain.LoadGrassCuts(cutpath); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Loaded mowed grass from " + cutpath)
+			}
+		default:
+			return false
+		}
+		return true
+	})
+
+	// sculpt the terrain where the camera looks at while the left mouse button is held,
+	// 1 to 5 select the brush, Z undoes and Y redoes a stroke and F9 saves the sculpted heightmap
+	brushes := []scene.Brush{
+		scene.MakeBrush(scene.RAISE, brushradius, 2.0),
+		scene.MakeBrush(scene.LOWER, brushradius, 2.0),
+		scene.MakeBrush(scene.SMOOTH, brushradius, 0.5),
+		scene.MakeBrush(scene.FLATTEN, brushradius, 0.5),
+		scene.MakeBrush(scene.NOISE, brushradius, 2.0),
+	}
+	brushnames := []string{"raise", "lower", "smooth", "flatten", "noise"}
+	brush := 0
+	sculpting := false
+	windowManager.AddMouseButtonHandler(func(leftPressed, rightPressed bool) bool {
+		// releasing the button finishes the stroke such that it can be undone as a whole
+		if sculpting && !leftPressed {
+			terrain.EndStroke()
+		}
+		sculpting = leftPressed
+		return false
+	})
+	windowManager.AddKeyPressHandler(func(key, action, mods int) bool {
+		if action != int(glfw.Press) {
+			return false
+		}
+		switch {
+		case key >= int(glfw.Key1) && key <= int(glfw.Key5):
+			brush = key - int(glfw.Key1)
+			fmt.Println("Sculpting with the " + brushnames[brush] + " brush")
+		case key == int(glfw.KeyZ):
+			if !terrain.Undo() {
+				fmt.Println("Nothing to undo")
+			}
+		case key == int(glfw.KeyY):
+			if !terrain.Redo() {
+				fmt.Println("Nothing to redo")
+			}
+		case key == int(glfw.KeyF9):
+			if err := terrain.SaveHeightmap(sculptpath); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Saved sculpted heightmap to " + sculptpath)
+			}
+		default:
+			return false
+		}
+		return true
+	})
+
+	// switch between the geometry and compute shader grass pipeline when pressing F8
+	windowManager.AddKeyPressHandler(func(key, action, mods int) bool {
+		if key == int(glfw.KeyF8) && action == int(glfw.Press) {
+			pipeline := scene.GEOMETRY
+			if terrain.GetGrassPipeline() == scene.GEOMETRY {
+				pipeline = scene.COMPUTE
+			}
+			if err := terrain.SetGrassPipeline(pipeline); err != nil {
+				fmt.Println(err)
+				return true
+			}
+			fmt.Println("Rendering grass with the " + pipeline.String() + " pipeline")
+			return true
+		}
+		return false
+	})
 	oldpos := camera.Pos
+	lastframe := time.Now()
 
 	// fbo
 	fbo := engine.MakeFBO(width, height)
@@ -72,15 +300,30 @@ func main() {
 	// main loop
 	render := func() {
 		// update title
-		windowManager.SetTitle("Grass " + strconv.FormatFloat(windowManager.GetFPS(), 'f', 0, 64) + "FPS")
+		windowManager.SetTitle("Grass " + strconv.FormatFloat(windowManager.GetFPS(), 'f', 0, 64) + "FPS " + terrain.GetGrassPipeline().String())
 
 		// update camera
+		now := time.Now()
+		dt := float32(now.Sub(lastframe).Seconds())
+		lastframe = now
 		camera.Update()
-		// collision check with terrain
-		y := terrain.GetHeight(camera.Pos) + grassHeight
-		if camera.Pos.Y() < y {
-			newy := mathutils.Interpolate(camera.Pos.Y(), y, 0.5)
-			camera.SetPos(mgl32.Vec3{camera.Pos.X(), newy, camera.Pos.Z()})
+		controller.Update(dt)
+
+		// the player pushes the grass aside and leaves a trail
+		terrain.ClearColliders()
+		terrain.AddCollider(scene.MakeSphereCollider(controller.GetPos(), playerradius))
+		if mowing {
+			terrain.CutGrass(scene.MakeCircleArea(controller.GetPos(), mowradius), mowheight)
+		}
+
+		// sculpt the terrain in the center of the screen
+		if sculpting {
+			if hit, ok := terrain.Raycast(camera.Pos, camera.Target.Sub(camera.Pos), viewdist); ok {
+				if err := terrain.Sculpt(brushes[brush], hit.Position); err != nil {
+					fmt.Println(err)
+					sculpting = false
+				}
+			}
 		}
 
 		// get camera matrices
@@ -96,12 +339,16 @@ func main() {
 		mvp := P.Mul4(V)
 		cameradelta := camera.Pos.Sub(oldpos)
 		cameradelta = mgl32.Vec3{cameradelta.X(), 0.0, cameradelta.Z()}
-		terrain.Update(camera.Pos, cameradelta, mvp)
+		terrain.Update(camera.Pos, cameradelta, mvp, dt)
 		terrain.Render(M, V, P, camera.Pos)
 
 		// done rendering into fbo
 		fbo.Unbind()
 
+		// render water above the submerged chunks
+		water.Update(terrain.GetVisibleChunks())
+		water.Render(M, &camera, &fbo, &sky)
+
 		// apply dof and bloom
 		pp.Bloom(&fbo)
 		pp.DOF(&fbo)
@@ -0,0 +1,305 @@
+// Package engine provides an abstraction layer on top of OpenGL.
+// It contains entities relevant for rendering.
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RawFloatImageData stores a single channel of image data with floating point precision on the CPU.
+// Values of integer formats are normalized to be between 0 and 1.
+// Values of floating point formats are used as they are stored in the file.
+type RawFloatImageData struct {
+	data       []float32
+	width      int32
+	height     int32
+	normalized bool
+}
+
+// MakeEmptyRawFloatImageData creates a RawFloatImageData of the given width and height filled with zeros.
+func MakeEmptyRawFloatImageData(width, height int32) RawFloatImageData {
+	return RawFloatImageData{
+		data:       make([]float32, width*height),
+		width:      width,
+		height:     height,
+		normalized: false,
+	}
+}
+
+// MakeRawFloatImageData loads the first channel of the image at the specified path.
+// The format is chosen by the file extension:
+// .r16 is raw little-endian 16 bit unsigned integers,
+// .r32 is raw little-endian 32 bit floats and
+// .pfm is a portable float map.
+// Files with the extension .raw are rejected since their format can't be told apart, they have to be renamed to .r16 or .r32.
+// Raw files have no header and thus have to be square.
+// All other files are decoded as PNG or JPEG keeping 16 bit precision if the image provides it.
+func MakeRawFloatImageData(path string) (RawFloatImageData, error) {
+	// load image file
+	file, err := os.Open(path)
+	if err != nil {
+		return RawFloatImageData{}, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".r16":
+		return decodeR16(file)
+	case ".raw":
+		return RawFloatImageData{}, fmt.Errorf("Unknown format of raw image %v, use the extension .r16 or .r32", path)
+	case ".r32":
+		return decodeR32F(file)
+	case ".pfm":
+		return decodePFM(file)
+	default:
+		return decodeImage(file)
+	}
+}
+
+// GetWidth returns the width of the image.
+func (data *RawFloatImageData) GetWidth() int32 {
+	return data.width
+}
+
+// GetHeight returns the height of the image.
+func (data *RawFloatImageData) GetHeight() int32 {
+	return data.height
+}
+
+// IsNormalized returns true if the values have been normalized from an integer format and thus are between 0 and 1.
+func (data *RawFloatImageData) IsNormalized() bool {
+	return data.normalized
+}
+
+// GetValue returns the value of the pixel at (x,y).
+func (data *RawFloatImageData) GetValue(x, y int32) float32 {
+	return data.data[y*data.width+x]
+}
+
+// SetValue sets the value of the pixel at (x,y).
+func (data *RawFloatImageData) SetValue(x, y int32, val float32) {
+	data.data[y*data.width+x] = val
+}
+
+// SavePNG writes the image as 16 bit grayscale PNG to the specified path.
+// Values are clamped between 0 and 1.
+func (data *RawFloatImageData) SavePNG(path string) error {
+	img := image.NewGray16(image.Rect(0, 0, int(data.width), int(data.height)))
+	var x, y int32
+	for y = 0; y < data.height; y++ {
+		for x = 0; x < data.width; x++ {
+			val := math.Max(0, math.Min(1, float64(data.GetValue(x, y))))
+			img.SetGray16(int(x), int(y), color.Gray16{Y: uint16(math.Round(val * 65535.0))})
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Save writes the image to the specified path in the format chosen by the file extension.
+// .r32 writes raw little-endian 32 bit floats and .pfm a grayscale portable float map, both keep all values as they are.
+// Raw files have no header and thus only square images can be written as .r32.
+// All other files are written as 16 bit grayscale PNG which clamps the values between 0 and 1.
+func (data *RawFloatImageData) Save(path string) error {
+	var encode func(writer io.Writer) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".r32":
+		if data.width != data.height {
+			return fmt.Errorf("Raw image %v has to be square but is %vx%v", path, data.width, data.height)
+		}
+		encode = data.encodeR32F
+	case ".pfm":
+		encode = data.encodePFM
+	default:
+		return data.SavePNG(path)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	if err := encode(writer); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// encodeR32F writes the image as raw little-endian 32 bit floats.
+func (data *RawFloatImageData) encodeR32F(writer io.Writer) error {
+	return binary.Write(writer, binary.LittleEndian, data.data)
+}
+
+// encodePFM writes the image as grayscale portable float map.
+// The data is stored little-endian with the rows from bottom to top.
+func (data *RawFloatImageData) encodePFM(writer io.Writer) error {
+	if _, err := fmt.Fprintf(writer, "Pf\n%v %v\n-1.0\n", data.width, data.height); err != nil {
+		return err
+	}
+	for y := data.height - 1; y >= 0; y-- {
+		row := data.data[y*data.width : (y+1)*data.width]
+		if err := binary.Write(writer, binary.LittleEndian, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeImage decodes a PNG or JPEG image and extracts the red channel with 16 bit precision.
+// Values of 8 bit images are normalized the same way since they get scaled up to 16 bit.
+func decodeImage(reader io.Reader) (RawFloatImageData, error) {
+	img, _, err := image.Decode(reader)
+	if err != nil {
+		return RawFloatImageData{}, err
+	}
+
+	bounds := img.Bounds()
+	data := MakeEmptyRawFloatImageData(int32(bounds.Dx()), int32(bounds.Dy()))
+	data.normalized = true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// RGBA always returns 16 bit values independent of the color model
+			r, _, _, _ := img.At(x, y).RGBA()
+			data.SetValue(int32(x-bounds.Min.X), int32(y-bounds.Min.Y), float32(r)/65535.0)
+		}
+	}
+
+	return data, nil
+}
+
+// decodeR16 decodes a square image of raw little-endian 16 bit unsigned integers.
+func decodeR16(reader io.Reader) (RawFloatImageData, error) {
+	bytes, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return RawFloatImageData{}, err
+	}
+
+	size, err := calcSquareSize(len(bytes), 2)
+	if err != nil {
+		return RawFloatImageData{}, err
+	}
+
+	data := MakeEmptyRawFloatImageData(size, size)
+	data.normalized = true
+	for i := range data.data {
+		val := binary.LittleEndian.Uint16(bytes[i*2:])
+		data.data[i] = float32(val) / 65535.0
+	}
+
+	return data, nil
+}
+
+// decodeR32F decodes a square image of raw little-endian 32 bit floats.
+func decodeR32F(reader io.Reader) (RawFloatImageData, error) {
+	bytes, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return RawFloatImageData{}, err
+	}
+
+	size, err := calcSquareSize(len(bytes), 4)
+	if err != nil {
+		return RawFloatImageData{}, err
+	}
+
+	data := MakeEmptyRawFloatImageData(size, size)
+	for i := range data.data {
+		bits := binary.LittleEndian.Uint32(bytes[i*4:])
+		data.data[i] = math.Float32frombits(bits)
+	}
+
+	return data, nil
+}
+
+// decodePFM decodes a portable float map.
+// The header consists of the type 'Pf' for grayscale or 'PF' for RGB, the dimensions and a scale.
+// A negative scale means that the data is stored little-endian.
+// Rows are stored from bottom to top and are flipped such that the first row is the top row.
+// For RGB images only the red channel is used.
+func decodePFM(reader io.Reader) (RawFloatImageData, error) {
+	buffered := bufio.NewReader(reader)
+
+	// read header
+	var (
+		format        string
+		width, height int32
+		scale         float64
+	)
+	if _, err := fmt.Fscan(buffered, &format, &width, &height, &scale); err != nil {
+		return RawFloatImageData{}, fmt.Errorf("Invalid PFM header: %v", err)
+	}
+	// exactly one whitespace character separates the header from the data
+	if _, err := buffered.ReadByte(); err != nil {
+		return RawFloatImageData{}, err
+	}
+
+	channels := 0
+	switch format {
+	case "Pf":
+		channels = 1
+	case "PF":
+		channels = 3
+	default:
+		return RawFloatImageData{}, fmt.Errorf("Unknown PFM format %v", format)
+	}
+	if width <= 0 || height <= 0 {
+		return RawFloatImageData{}, fmt.Errorf("Invalid PFM dimensions %vx%v", width, height)
+	}
+
+	var byteorder binary.ByteOrder = binary.BigEndian
+	if scale < 0 {
+		byteorder = binary.LittleEndian
+	}
+
+	// read pixel data
+	bytes := make([]byte, int(width)*int(height)*channels*4)
+	if _, err := io.ReadFull(buffered, bytes); err != nil {
+		return RawFloatImageData{}, err
+	}
+
+	data := MakeEmptyRawFloatImageData(width, height)
+	var x, y int32
+	for y = 0; y < height; y++ {
+		for x = 0; x < width; x++ {
+			idx := (int(y)*int(width) + int(x)) * channels * 4
+			val := math.Float32frombits(byteorder.Uint32(bytes[idx:]))
+			data.SetValue(x, height-1-y, val)
+		}
+	}
+
+	return data, nil
+}
+
+// calcSquareSize returns the side length of a square image with bytecount bytes and pixelsize bytes per pixel.
+func calcSquareSize(bytecount, pixelsize int) (int32, error) {
+	pixelcount := bytecount / pixelsize
+	size := int(math.Sqrt(float64(pixelcount)))
+	if size == 0 || bytecount%pixelsize != 0 || size*size != pixelcount {
+		return 0, fmt.Errorf("Raw image with %v bytes is not square", bytecount)
+	}
+	return int32(size), nil
+}
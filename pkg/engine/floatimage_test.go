@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// expectValues fails the test if the image doesn't have the given size and row-major values.
+func expectValues(t *testing.T, data RawFloatImageData, width, height int32, values []float32) {
+	t.Helper()
+	if data.GetWidth() != width || data.GetHeight() != height {
+		t.Fatalf("expected size %vx%v but got %vx%v", width, height, data.GetWidth(), data.GetHeight())
+	}
+	for i, val := range values {
+		x, y := int32(i)%width, int32(i)/width
+		if math.Abs(float64(data.GetValue(x, y)-val)) > 1e-6 {
+			t.Fatalf("expected %v at (%v,%v) but got %v", val, x, y, data.GetValue(x, y))
+		}
+	}
+}
+
+func TestCalcSquareSize(t *testing.T) {
+	for _, test := range []struct {
+		bytecount, pixelsize int
+		size                 int32
+		valid                bool
+	}{
+		{8, 2, 2, true},
+		{64, 4, 4, true},
+		{2, 2, 1, true},
+		{0, 2, 0, false},
+		{6, 2, 0, false},
+		{9, 2, 0, false},
+		{12, 4, 0, false},
+	} {
+		size, err := calcSquareSize(test.bytecount, test.pixelsize)
+		if (err == nil) != test.valid || size != test.size {
+			t.Fatalf("%v bytes of %v bytes per pixel: expected size %v valid %v but got %v %v", test.bytecount, test.pixelsize, test.size, test.valid, size, err)
+		}
+	}
+}
+
+func TestDecodeR16(t *testing.T) {
+	raw := []byte{0x00, 0x00, 0xff, 0xff, 0x00, 0x80, 0x01, 0x00}
+	data, err := decodeR16(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValues(t, data, 2, 2, []float32{0, 1, 32768.0 / 65535.0, 1.0 / 65535.0})
+	if !data.IsNormalized() {
+		t.Fatal("expected R16 data to be normalized")
+	}
+
+	if _, err := decodeR16(bytes.NewReader(raw[:6])); err == nil {
+		t.Fatal("expected an error for a non square image")
+	}
+}
+
+func TestDecodeR32F(t *testing.T) {
+	values := []float32{-1.5, 0, 2.25, 1000}
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.LittleEndian, values)
+	data, err := decodeR32F(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValues(t, data, 2, 2, values)
+	if data.IsNormalized() {
+		t.Fatal("expected R32F data to keep its values")
+	}
+}
+
+// makePFM encodes a PFM with the given header and the rows from bottom to top.
+func makePFM(header string, order binary.ByteOrder, values []float32) *bytes.Buffer {
+	buffer := bytes.NewBufferString(header)
+	binary.Write(buffer, order, values)
+	return buffer
+}
+
+func TestDecodePFM(t *testing.T) {
+	// rows are stored from bottom to top
+	stored := []float32{1, 2, 3, 4, 5, 6}
+	top := []float32{4, 5, 6, 1, 2, 3}
+
+	// a negative scale means little-endian and a positive one big-endian
+	data, err := decodePFM(makePFM("Pf\n3 2\n-1.0\n", binary.LittleEndian, stored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValues(t, data, 3, 2, top)
+	data, err = decodePFM(makePFM("Pf\n3 2\n1.0\n", binary.BigEndian, stored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValues(t, data, 3, 2, top)
+
+	// only the red channel of RGB images is used
+	rgb := make([]float32, 0, 3*len(stored))
+	for _, val := range stored {
+		rgb = append(rgb, val, -val, 100*val)
+	}
+	data, err = decodePFM(makePFM("PF\n3 2\n-1.0\n", binary.LittleEndian, rgb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValues(t, data, 3, 2, top)
+	if data.IsNormalized() {
+		t.Fatal("expected PFM data to keep its values")
+	}
+}
+
+func TestDecodePFMErrors(t *testing.T) {
+	for _, header := range []string{"P6\n3 2\n-1.0\n", "Pf\n0 2\n-1.0\n", "Pf\n3\n"} {
+		if _, err := decodePFM(makePFM(header, binary.LittleEndian, []float32{1, 2, 3, 4, 5, 6})); err == nil {
+			t.Fatalf("expected an error for the header %q", header)
+		}
+	}
+
+	// missing pixel data
+	if _, err := decodePFM(makePFM("Pf\n3 2\n-1.0\n", binary.LittleEndian, []float32{1, 2, 3})); err == nil {
+		t.Fatal("expected an error for missing pixel data")
+	}
+}
+
+func TestRawExtensionRejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "heightmap.raw")
+	if err := ioutil.WriteFile(path, make([]byte, 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MakeRawFloatImageData(path); err == nil {
+		t.Fatal("expected an error for a raw image of unknown format")
+	}
+}
+
+func TestSaveFloatFormats(t *testing.T) {
+	data := MakeEmptyRawFloatImageData(3, 2)
+	values := []float32{-1.5, 0, 2.25, 1000, 0.5, -7}
+	for i, val := range values {
+		data.SetValue(int32(i)%3, int32(i)/3, val)
+	}
+
+	// PFM keeps non-square images and all values
+	path := filepath.Join(t.TempDir(), "heightmap.pfm")
+	if err := data.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := MakeRawFloatImageData(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValues(t, loaded, 3, 2, values)
+
+	// raw files have to be square
+	if err := data.Save(filepath.Join(t.TempDir(), "heightmap.r32")); err == nil {
+		t.Fatal("expected an error for a non-square raw image")
+	}
+	square := MakeEmptyRawFloatImageData(2, 2)
+	for i, val := range values[:4] {
+		square.SetValue(int32(i)%2, int32(i)/2, val)
+	}
+	path = filepath.Join(t.TempDir(), "heightmap.r32")
+	if err := square.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = MakeRawFloatImageData(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectValues(t, loaded, 2, 2, values[:4])
+}
@@ -0,0 +1,280 @@
+// Package engine provides an abstraction layer on top of OpenGL.
+// It contains entities relevant for rendering.
+package engine
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// MeshData is a named indexed triangle mesh that can be written to a file.
+// Vertices and Normals contain 3 floats per vertex while Texcoords contains 2 floats per vertex.
+// Indices contains 3 vertex indices per triangle with the triangles being defined counter-clockwise.
+type MeshData struct {
+	Name      string
+	Vertices  []float32
+	Normals   []float32
+	Texcoords []float32
+	Indices   []uint32
+}
+
+// SaveObj writes all meshes as separate objects into a Wavefront OBJ file at the specified path.
+func SaveObj(path string, meshes []MeshData) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+
+	// obj indices are global and start at 1
+	var offset uint32 = 1
+	for _, mesh := range meshes {
+		fmt.Fprintf(writer, "o %v\n", mesh.Name)
+		for i := 0; i+2 < len(mesh.Vertices); i += 3 {
+			fmt.Fprintf(writer, "v %v %v %v\n", mesh.Vertices[i], mesh.Vertices[i+1], mesh.Vertices[i+2])
+		}
+		for i := 0; i+1 < len(mesh.Texcoords); i += 2 {
+			fmt.Fprintf(writer, "vt %v %v\n", mesh.Texcoords[i], mesh.Texcoords[i+1])
+		}
+		for i := 0; i+2 < len(mesh.Normals); i += 3 {
+			fmt.Fprintf(writer, "vn %v %v %v\n", mesh.Normals[i], mesh.Normals[i+1], mesh.Normals[i+2])
+		}
+		for i := 0; i+2 < len(mesh.Indices); i += 3 {
+			a := mesh.Indices[i] + offset
+			b := mesh.Indices[i+1] + offset
+			c := mesh.Indices[i+2] + offset
+			fmt.Fprintf(writer, "f %v/%v/%v %v/%v/%v %v/%v/%v\n", a, a, a, b, b, b, c, c, c)
+		}
+		offset += uint32(len(mesh.Vertices) / 3)
+	}
+
+	return writer.Flush()
+}
+
+// SavePly writes all meshes into a binary little endian PLY file at the specified path.
+// PLY only supports a single mesh, thus all meshes are merged and their names are written as comments.
+func SavePly(path string, meshes []MeshData) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+
+	// count vertices and faces of all meshes
+	vertexcount, facecount := 0, 0
+	for _, mesh := range meshes {
+		vertexcount += len(mesh.Vertices) / 3
+		facecount += len(mesh.Indices) / 3
+	}
+
+	// write header
+	fmt.Fprintf(writer, "ply\nformat binary_little_endian 1.0\n")
+	for _, mesh := range meshes {
+		fmt.Fprintf(writer, "comment object %v\n", mesh.Name)
+	}
+	fmt.Fprintf(writer, "element vertex %v\n", vertexcount)
+	fmt.Fprintf(writer, "property float x\nproperty float y\nproperty float z\n")
+	fmt.Fprintf(writer, "property float nx\nproperty float ny\nproperty float nz\n")
+	fmt.Fprintf(writer, "property float s\nproperty float t\n")
+	fmt.Fprintf(writer, "element face %v\n", facecount)
+	fmt.Fprintf(writer, "property list uchar uint vertex_indices\n")
+	fmt.Fprintf(writer, "end_header\n")
+
+	// write vertices
+	for _, mesh := range meshes {
+		for v := 0; v < len(mesh.Vertices)/3; v++ {
+			values := []float32{
+				mesh.Vertices[3*v], mesh.Vertices[3*v+1], mesh.Vertices[3*v+2],
+				getMeshValue(mesh.Normals, 3*v), getMeshValue(mesh.Normals, 3*v+1), getMeshValue(mesh.Normals, 3*v+2),
+				getMeshValue(mesh.Texcoords, 2*v), getMeshValue(mesh.Texcoords, 2*v+1),
+			}
+			if err := binary.Write(writer, binary.LittleEndian, values); err != nil {
+				return err
+			}
+		}
+	}
+
+	// write faces with indices relative to the merged vertices
+	var offset uint32
+	for _, mesh := range meshes {
+		for i := 0; i+2 < len(mesh.Indices); i += 3 {
+			writer.WriteByte(3)
+			face := []uint32{mesh.Indices[i] + offset, mesh.Indices[i+1] + offset, mesh.Indices[i+2] + offset}
+			if err := binary.Write(writer, binary.LittleEndian, face); err != nil {
+				return err
+			}
+		}
+		offset += uint32(len(mesh.Vertices) / 3)
+	}
+
+	return writer.Flush()
+}
+
+// gltf types only contain the parts of the glTF 2.0 specification that are needed to store triangle meshes.
+type gltfFile struct {
+	Asset       gltfAsset        `json:"asset"`
+	Scene       int              `json:"scene"`
+	Scenes      []gltfScene      `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+}
+type gltfAsset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator"`
+}
+type gltfScene struct {
+	Nodes []int `json:"nodes"`
+}
+type gltfNode struct {
+	Name string `json:"name"`
+	Mesh int    `json:"mesh"`
+}
+type gltfMesh struct {
+	Name       string          `json:"name"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Mode       int            `json:"mode"`
+}
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float32 `json:"min,omitempty"`
+	Max           []float32 `json:"max,omitempty"`
+}
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target"`
+}
+type gltfBuffer struct {
+	ByteLength int    `json:"byteLength"`
+	URI        string `json:"uri"`
+}
+
+// constants of the glTF 2.0 specification.
+const (
+	gltfFloat        = 5126
+	gltfUnsignedInt  = 5125
+	gltfArrayBuffer  = 34962
+	gltfElementArray = 34963
+	gltfTriangles    = 4
+)
+
+// SaveGltf writes all meshes as separate nodes into a glTF 2.0 file at the specified path.
+// The binary data is embedded into the file as base64 encoded data URI, thus the file is self-contained.
+func SaveGltf(path string, meshes []MeshData) error {
+	doc := gltfFile{
+		Asset:  gltfAsset{Version: "2.0", Generator: "realtime-grass"},
+		Scene:  0,
+		Scenes: []gltfScene{{Nodes: []int{}}},
+	}
+
+	// append all attributes and indices to a single buffer
+	var data []byte
+	addView := func(values interface{}, bytelength, target int) int {
+		view := gltfBufferView{Buffer: 0, ByteOffset: len(data), ByteLength: bytelength, Target: target}
+		buf := make([]byte, bytelength)
+		switch v := values.(type) {
+		case []float32:
+			for i, f := range v {
+				binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+			}
+		case []uint32:
+			for i, u := range v {
+				binary.LittleEndian.PutUint32(buf[4*i:], u)
+			}
+		}
+		data = append(data, buf...)
+		doc.BufferViews = append(doc.BufferViews, view)
+		return len(doc.BufferViews) - 1
+	}
+	addAccessor := func(accessor gltfAccessor) int {
+		doc.Accessors = append(doc.Accessors, accessor)
+		return len(doc.Accessors) - 1
+	}
+
+	for _, mesh := range meshes {
+		count := len(mesh.Vertices) / 3
+		if count == 0 || len(mesh.Indices) == 0 {
+			continue
+		}
+		attributes := map[string]int{}
+
+		// positions need their bounds
+		min, max := calcMeshBounds(mesh.Vertices)
+		view := addView(mesh.Vertices[:3*count], 12*count, gltfArrayBuffer)
+		attributes["POSITION"] = addAccessor(gltfAccessor{BufferView: view, ComponentType: gltfFloat, Count: count, Type: "VEC3", Min: min, Max: max})
+		if len(mesh.Normals) >= 3*count {
+			view = addView(mesh.Normals[:3*count], 12*count, gltfArrayBuffer)
+			attributes["NORMAL"] = addAccessor(gltfAccessor{BufferView: view, ComponentType: gltfFloat, Count: count, Type: "VEC3"})
+		}
+		if len(mesh.Texcoords) >= 2*count {
+			view = addView(mesh.Texcoords[:2*count], 8*count, gltfArrayBuffer)
+			attributes["TEXCOORD_0"] = addAccessor(gltfAccessor{BufferView: view, ComponentType: gltfFloat, Count: count, Type: "VEC2"})
+		}
+		view = addView(mesh.Indices, 4*len(mesh.Indices), gltfElementArray)
+		indices := addAccessor(gltfAccessor{BufferView: view, ComponentType: gltfUnsignedInt, Count: len(mesh.Indices), Type: "SCALAR"})
+
+		// every mesh gets its own node
+		doc.Meshes = append(doc.Meshes, gltfMesh{
+			Name:       mesh.Name,
+			Primitives: []gltfPrimitive{{Attributes: attributes, Indices: indices, Mode: gltfTriangles}},
+		})
+		doc.Nodes = append(doc.Nodes, gltfNode{Name: mesh.Name, Mesh: len(doc.Meshes) - 1})
+		doc.Scenes[0].Nodes = append(doc.Scenes[0].Nodes, len(doc.Nodes)-1)
+	}
+
+	doc.Buffers = []gltfBuffer{{
+		ByteLength: len(data),
+		URI:        "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(data),
+	}}
+
+	// write json
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewEncoder(file).Encode(doc)
+}
+
+// calcMeshBounds returns the component wise minimum and maximum of all vertices.
+func calcMeshBounds(vertices []float32) ([]float32, []float32) {
+	min := []float32{math.MaxFloat32, math.MaxFloat32, math.MaxFloat32}
+	max := []float32{-math.MaxFloat32, -math.MaxFloat32, -math.MaxFloat32}
+	for i := 0; i+2 < len(vertices); i += 3 {
+		for c := 0; c < 3; c++ {
+			if vertices[i+c] < min[c] {
+				min[c] = vertices[i+c]
+			}
+			if vertices[i+c] > max[c] {
+				max[c] = vertices[i+c]
+			}
+		}
+	}
+	return min, max
+}
+
+// getMeshValue returns the value at idx or 0 if the attribute is missing.
+func getMeshValue(values []float32, idx int) float32 {
+	if idx < len(values) {
+		return values[idx]
+	}
+	return 0.0
+}
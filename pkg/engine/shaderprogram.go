@@ -3,6 +3,8 @@ package engine
 import (
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/go-gl/gl/v4.3-core/gl"
@@ -254,15 +256,44 @@ func (shaderProgram *ShaderProgram) GetHandle() uint32 {
 }
 
 // loadFile returns the contents of a file as a zero terminated string.
-func loadFile(filepath string) (string, error) {
-	bytes, err := ioutil.ReadFile(filepath)
+// Include directives are replaced by the files they include, see resolveIncludes.
+func loadFile(path string) (string, error) {
+	bytes, err := ioutil.ReadFile(path)
 
 	if err != nil {
 		return "", err
 	}
 
-	bytes = append(bytes, '\000')
-	return string(bytes), nil
+	source, err := resolveIncludes(string(bytes), filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+
+	return source + "\000", nil
+}
+
+// resolveIncludes replaces each line of the form #include "name" by the contents of the file name relative to dir.
+// This way several shaders can share common code, the directive has to follow the #version line.
+// Included files can't include other files.
+// A #line directive after each included file keeps the line numbers of compile errors of the shader correct.
+func resolveIncludes(source, dir string) (string, error) {
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "#include") {
+			continue
+		}
+		name, err := strconv.Unquote(strings.TrimSpace(strings.TrimPrefix(trimmed, "#include")))
+		if err != nil {
+			return "", fmt.Errorf("Invalid include in line %v: %v", i+1, trimmed)
+		}
+		included, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		lines[i] = fmt.Sprintf("%v\n#line %v", strings.TrimRight(string(included), "\n"), i+2)
+	}
+	return strings.Join(lines, "\n"), nil
 }
 
 // compileShader compiles a shader with the specified shaderType.
@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveIncludes(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "common.glsl"), []byte("const float PI = 3.14;\nfloat half(float x) { return x/2; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	source := "#version 430\n\n#include \"common.glsl\"\n\nvoid main() {}"
+	resolved, err := resolveIncludes(source, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "#version 430\n\nconst float PI = 3.14;\nfloat half(float x) { return x/2; }\n#line 4\n\nvoid main() {}"
+	if resolved != expected {
+		t.Fatalf("expected\n%v\nbut got\n%v", expected, resolved)
+	}
+
+	// sources without includes are kept as they are
+	if resolved, err := resolveIncludes("#version 430\nvoid main() {}", dir); err != nil || resolved != "#version 430\nvoid main() {}" {
+		t.Fatalf("expected the source to be unchanged but got %v: %v", resolved, err)
+	}
+}
+
+func TestResolveIncludesErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := resolveIncludes("#version 430\n#include common.glsl\n", dir); err == nil {
+		t.Fatal("expected an error for an unquoted include")
+	}
+	if _, err := resolveIncludes("#version 430\n#include \"missing.glsl\"\n", dir); err == nil {
+		t.Fatal("expected an error for a missing include")
+	}
+}
@@ -161,6 +161,15 @@ func (ssbo *SSBO) UploadArrayInRange(values []float32, start, len int) {
 	gl.BindBuffer(gl.SHADER_STORAGE_BUFFER, 0)
 }
 
+// UploadIntArrayInRange replaces the data on the GPU with the integers in values in the range from start to start+len.
+// Make sure that the size of values matches the bytesize*len specified in the arguments.
+func (ssbo *SSBO) UploadIntArrayInRange(values []int32, start, len int) {
+	// upload array content to part of ssbo
+	gl.BindBuffer(gl.SHADER_STORAGE_BUFFER, ssbo.handle)
+	gl.BufferSubData(gl.SHADER_STORAGE_BUFFER, ssbo.typesize*start, ssbo.typesize*len, gl.Ptr(values))
+	gl.BindBuffer(gl.SHADER_STORAGE_BUFFER, 0)
+}
+
 // Download returns a copy of the data on GPU.
 func (ssbo *SSBO) Download() []float32 {
 	// create slice of the right size
@@ -31,7 +31,9 @@ func (vao *VAO) Delete() {
 			vertBuf.Delete()
 		}
 	}
-	vao.indexBuffer.Delete()
+	if vao.indexBuffer != nil {
+		vao.indexBuffer.Delete()
+	}
 
 	// delete vertex array
 	gl.DeleteVertexArrays(1, &vao.handle)
@@ -65,6 +67,17 @@ func (vao *VAO) RenderInstanced(instancecount int32) {
 	gl.BindVertexArray(0)
 }
 
+// RenderIndirect draws the geometry with the draw parameters stored in the command buffer on the GPU.
+// The command buffer holds the vertex count, instance count, first vertex and base instance as unsigned integers.
+// The vertices are generated in the vertex shader, thus no vertex buffers are needed.
+func (vao *VAO) RenderIndirect(command *SSBO) {
+	gl.BindVertexArray(vao.handle)
+	gl.BindBuffer(gl.DRAW_INDIRECT_BUFFER, command.handle)
+	gl.DrawArraysIndirect(vao.mode, nil)
+	gl.BindBuffer(gl.DRAW_INDIRECT_BUFFER, 0)
+	gl.BindVertexArray(0)
+}
+
 // AddVertexBuffer adds a vertex buffer at the end.
 func (vao *VAO) AddVertexBuffer(vbo *VBO) {
 	vao.vertexBuffers = append(vao.vertexBuffers, vbo)
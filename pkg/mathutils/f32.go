@@ -27,3 +27,13 @@ func CeilF32(val float32) float32 {
 func SqrtF32(val float32) float32 {
 	return float32(math.Sqrt(float64(val)))
 }
+
+// MinF32 is a float32 wrapper for the float64 function math.Min.
+func MinF32(vala, valb float32) float32 {
+	return float32(math.Min(float64(vala), float64(valb)))
+}
+
+// FloorF32 is a float32 wrapper for the float64 function math.Floor.
+func FloorF32(val float32) float32 {
+	return float32(math.Floor(float64(val)))
+}
@@ -0,0 +1,124 @@
+// Package mathutils provides utility functions for scalar and vectorial math.
+package mathutils
+
+import (
+	"math"
+	"math/rand"
+)
+
+// gradients2D are the gradient directions used by both Perlin and Simplex noise.
+var gradients2D = [8][2]float64{
+	{1, 0}, {-1, 0}, {0, 1}, {0, -1},
+	{0.70710678, 0.70710678}, {-0.70710678, 0.70710678},
+	{0.70710678, -0.70710678}, {-0.70710678, -0.70710678},
+}
+
+// Noise generates 2D gradient noise from a seeded permutation table.
+// The same seed always yields the same noise values.
+type Noise struct {
+	perm [512]uint8
+}
+
+// MakeNoise constructs a Noise with a permutation table that is shuffled using the seed.
+func MakeNoise(seed int64) Noise {
+	rng := rand.New(rand.NewSource(seed))
+
+	// shuffle the values 0 to 255 and repeat them once to avoid wrapping the index
+	noise := Noise{}
+	for i, p := range rng.Perm(256) {
+		noise.perm[i] = uint8(p)
+		noise.perm[i+256] = uint8(p)
+	}
+	return noise
+}
+
+// Perlin returns the 2D Perlin noise value at (x,y).
+// The value is approximately between -1 and 1.
+func (noise *Noise) Perlin(x, y float64) float64 {
+	// lattice cell and position within the cell
+	fx := math.Floor(x)
+	fy := math.Floor(y)
+	ix := int(fx) & 255
+	iy := int(fy) & 255
+	dx := x - fx
+	dy := y - fy
+
+	// contributions of the four corners of the cell
+	n00 := noise.gradDot(noise.hash(ix, iy), dx, dy)
+	n10 := noise.gradDot(noise.hash(ix+1, iy), dx-1, dy)
+	n01 := noise.gradDot(noise.hash(ix, iy+1), dx, dy-1)
+	n11 := noise.gradDot(noise.hash(ix+1, iy+1), dx-1, dy-1)
+
+	// blend the contributions with a quintic fade curve
+	u := fade(dx)
+	v := fade(dy)
+	nx0 := n00 + u*(n10-n00)
+	nx1 := n01 + u*(n11-n01)
+	return math.Sqrt2 * (nx0 + v*(nx1-nx0))
+}
+
+// Simplex returns the 2D Simplex noise value at (x,y).
+// The value is approximately between -1 and 1.
+// Source: http://staffwww.itn.liu.se/~stegu/simplexnoise/simplexnoise.pdf.
+func (noise *Noise) Simplex(x, y float64) float64 {
+	const (
+		f2 = 0.36602540378 // (sqrt(3)-1)/2
+		g2 = 0.21132486540 // (3-sqrt(3))/6
+	)
+
+	// skew the input space to determine the simplex cell
+	s := (x + y) * f2
+	fi := math.Floor(x + s)
+	fj := math.Floor(y + s)
+	t := (fi + fj) * g2
+	x0 := x - (fi - t)
+	y0 := y - (fj - t)
+
+	// determine which of the two triangles of the cell the point is in
+	i1, j1 := 0, 1
+	if x0 > y0 {
+		i1, j1 = 1, 0
+	}
+
+	// offsets of the other two corners
+	x1 := x0 - float64(i1) + g2
+	y1 := y0 - float64(j1) + g2
+	x2 := x0 - 1 + 2*g2
+	y2 := y0 - 1 + 2*g2
+
+	// sum up the contributions of all three corners
+	ii := int(fi) & 255
+	jj := int(fj) & 255
+	n := noise.corner(noise.hash(ii, jj), x0, y0)
+	n += noise.corner(noise.hash(ii+i1, jj+j1), x1, y1)
+	n += noise.corner(noise.hash(ii+1, jj+1), x2, y2)
+
+	// scale the result to be approximately between -1 and 1
+	return 70.0 * n
+}
+
+// hash returns the permutation value of the lattice point (x,y).
+func (noise *Noise) hash(x, y int) uint8 {
+	return noise.perm[int(noise.perm[x&255])+(y&255)]
+}
+
+// gradDot returns the dot product of the gradient selected by hash and the vector (x,y).
+func (noise *Noise) gradDot(hash uint8, x, y float64) float64 {
+	g := gradients2D[hash&7]
+	return g[0]*x + g[1]*y
+}
+
+// corner returns the contribution of one simplex corner at the offset (x,y).
+func (noise *Noise) corner(hash uint8, x, y float64) float64 {
+	t := 0.5 - x*x - y*y
+	if t < 0 {
+		return 0
+	}
+	t *= t
+	return t * t * noise.gradDot(hash, x, y)
+}
+
+// fade is the quintic interpolation curve 6t^5 - 15t^4 + 10t^3.
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
@@ -10,29 +10,48 @@ import (
 // Each Chunk consists of multiple chunks specified by the chunkresolution.
 // To create the Tiles it has a reference to the TileFactory.
 // The chunkheight is the terrain height plus the maximum height of the grass.
+// The optional GrassCuts provide the height multipliers of the grass of each Tile.
 type ChunkFactory struct {
 	chunksize       float32
 	chunkheight     float32
 	terrainheight   float32
 	chunkresolution int32
 
-	tf *TileFactory
+	tf   *TileFactory
+	cuts *GrassCuts
 }
 
 // Chunk is a collection of Tiles.
-// In addition a chunk has a position and an AABB.
-// poss are all Tile positions while data is the Tile data of all Tiles.
+// In addition a chunk has a level of detail lod, a coordinate (x,z) in the grid of that level, a position and an AABB.
+// A Chunk of level lod covers 2^lod Chunks of level 0 in x and z direction with the same number of Tiles.
+// data is the Tile data of all Tiles, which also contains the Tile positions.
+// heights are the height multipliers of the grass of all Tiles.
+// While the Chunk is loaded slot is the index of its Tile data on the GPU, otherwise it is -1.
+// A Chunk is submerged if at least one of its Tiles is below the sea level.
 type Chunk struct {
-	pos  mgl32.Vec3
-	aabb collision.AABB
-	poss []float32
-	data []float32
+	lod       int32
+	x         int32
+	z         int32
+	slot      int32
+	pos       mgl32.Vec3
+	aabb      collision.AABB
+	data      []float32
+	heights   []float32
+	submerged bool
 }
 
 // MakeChunk creates a single Chunk at position (cx,cz).
+// It only reads from the TileFactory and can thus be called from several goroutines at once.
 func (cf *ChunkFactory) MakeChunk(cx, cz int32) Chunk {
-	pos := makeCenteredVec(cx, cz, cf.chunkheight/2, cf.chunksize)
-	dir := makeCenteredVec(0, 0, cf.chunkheight/2, cf.chunksize)
+	return cf.MakeLODChunk(0, cx, cz)
+}
+
+// MakeLODChunk creates a single Chunk of the level of detail lod at position (cx,cz) in the grid of that level.
+// It only reads from the TileFactory and can thus be called from several goroutines at once.
+func (cf *ChunkFactory) MakeLODChunk(lod, cx, cz int32) Chunk {
+	size := cf.chunksize * float32(int32(1)<<uint(lod))
+	pos := makeCenteredVec(cx, cz, cf.chunkheight/2, size)
+	dir := makeCenteredVec(0, 0, cf.chunkheight/2, size)
 
 	// create AABB around the Chunk
 	aabb := collision.MakeAABB(pos.Sub(dir), pos.Add(dir))
@@ -41,26 +60,60 @@ func (cf *ChunkFactory) MakeChunk(cx, cz int32) Chunk {
 	acx := cx * cf.chunkresolution
 	acz := cz * cf.chunkresolution
 
-	// create all Tiles and collect all positions and Tile data
-	var (
-		poss []float32 // center positions of all Tiles
-		data []float32 // Tile data of all Tiles
-	)
+	// create all Tiles and collect the Tile data
+	var data []float32
 	var tx, tz int32
+	submerged := false
 	for tz = 0; tz < cf.chunkresolution; tz++ {
 		for tx = 0; tx < cf.chunkresolution; tx++ {
-			tile := cf.tf.MakeTile(acx+tx, acz+tz)
-			poss = append(poss, tile.pos...)
+			tile := cf.tf.MakeLODTile(lod, acx+tx, acz+tz)
 			data = append(data, tile.data...)
+			// check whether the Tile is under water
+			_, _, issubmerged := unpackMaterial(tile.data[11])
+			submerged = submerged || issubmerged
 		}
 	}
 
 	return Chunk{
-		pos:  pos,
-		aabb: aabb,
-		poss: poss,
-		data: data,
+		lod:       lod,
+		x:         cx,
+		z:         cz,
+		slot:      -1,
+		pos:       pos,
+		aabb:      aabb,
+		data:      data,
+		heights:   cf.makeHeights(lod, cx, cz),
+		submerged: submerged,
+	}
+}
+
+// makeHeights returns the height multipliers of the grass of all Tiles of the Chunk of level lod at (cx,cz).
+// Without GrassCuts all grass grows at its full height.
+func (cf *ChunkFactory) makeHeights(lod, cx, cz int32) []float32 {
+	if cf.cuts == nil {
+		heights := make([]float32, cf.chunkresolution*cf.chunkresolution)
+		for i := range heights {
+			heights[i] = 1.0
+		}
+		return heights
 	}
+	return cf.cuts.calcChunkHeights(lod, cx, cz, cf.chunkresolution)
+}
+
+// key returns the unique key of the Chunk.
+func (chunk *Chunk) key() string {
+	return makeLODKey(chunk.lod, chunk.x, chunk.z)
+}
+
+// byteSize returns the number of bytes of the Tile data and grass heights of the Chunk.
+func (chunk *Chunk) byteSize() int {
+	return (len(chunk.data) + len(chunk.heights)) * 4
+}
+
+// getTilePlanes returns the plane equations of both triangles of the Tile with the index tidx within the Chunk.
+func (chunk *Chunk) getTilePlanes(tidx int32) (mgl32.Vec4, mgl32.Vec4) {
+	d := chunk.data[tidx*12 : tidx*12+8]
+	return mgl32.Vec4{d[0], d[1], d[2], d[3]}, mgl32.Vec4{d[4], d[5], d[6], d[7]}
 }
 
 // makeCenteredVec creates a Chunk centered vec3 with the provided height.
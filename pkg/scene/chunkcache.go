@@ -0,0 +1,130 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import "container/list"
+
+// ChunkCacheStats contains the counters of a ChunkCache.
+type ChunkCacheStats struct {
+	Hits      int
+	Misses    int
+	Evictions int
+	Count     int
+	Bytes     int
+}
+
+// ChunkCache keeps recently unloaded Chunks around such that they don't have to be built again when the camera returns.
+// It is bounded by the number of Chunks and by their size in bytes.
+// When one of the bounds is exceeded the least recently used Chunks are evicted.
+type ChunkCache struct {
+	entries  map[string]*list.Element
+	order    *list.List
+	maxcount int
+	maxbytes int
+	bytes    int
+	stats    ChunkCacheStats
+}
+
+// MakeChunkCache constructs a ChunkCache holding at most maxcount Chunks and maxbytes bytes of Chunk data.
+// A bound of 0 means that the respective bound is not checked.
+func MakeChunkCache(maxcount, maxbytes int) ChunkCache {
+	return ChunkCache{
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+		maxcount: maxcount,
+		maxbytes: maxbytes,
+		bytes:    0,
+	}
+}
+
+// Put adds an unloaded Chunk to the ChunkCache as the most recently used one.
+func (cache *ChunkCache) Put(chunk Chunk) {
+	key := chunk.key()
+
+	// replace a previous entry of the same chunk
+	if element, ok := cache.entries[key]; ok {
+		cache.remove(element)
+	}
+
+	element := cache.order.PushFront(chunk)
+	cache.entries[key] = element
+	cache.bytes += chunk.byteSize()
+
+	cache.evict()
+}
+
+// Get removes the Chunk of level lod at (x,z) from the ChunkCache and returns it.
+// The second return value is false if the Chunk is not in the ChunkCache.
+// Only hits are counted, since a missing Chunk can be looked up several times until it has been requested, see RecordMiss.
+func (cache *ChunkCache) Get(lod, x, z int32) (Chunk, bool) {
+	element, ok := cache.entries[makeLODKey(lod, x, z)]
+	if !ok {
+		return Chunk{}, false
+	}
+
+	cache.stats.Hits++
+	cache.remove(element)
+	return element.Value.(Chunk), true
+}
+
+// RecordMiss counts a Chunk that was not in the ChunkCache and had to be requested from the ChunkLoader.
+func (cache *ChunkCache) RecordMiss() {
+	cache.stats.Misses++
+}
+
+// Invalidate removes the Chunk of level lod at (x,z) from the ChunkCache without counting it as a hit or miss.
+func (cache *ChunkCache) Invalidate(lod, x, z int32) {
+	if element, ok := cache.entries[makeLODKey(lod, x, z)]; ok {
+		cache.remove(element)
+	}
+}
+
+// InvalidateFunc removes all Chunks from the ChunkCache for which invalid returns true.
+func (cache *ChunkCache) InvalidateFunc(invalid func(chunk *Chunk) bool) {
+	for _, element := range cache.entries {
+		chunk := element.Value.(Chunk)
+		if invalid(&chunk) {
+			cache.remove(element)
+		}
+	}
+}
+
+// Clear removes all Chunks from the ChunkCache.
+func (cache *ChunkCache) Clear() {
+	cache.entries = map[string]*list.Element{}
+	cache.order.Init()
+	cache.bytes = 0
+}
+
+// SetLimits changes the bounds of the ChunkCache and evicts Chunks if necessary.
+// A bound of 0 means that the respective bound is not checked.
+func (cache *ChunkCache) SetLimits(maxcount, maxbytes int) {
+	cache.maxcount = maxcount
+	cache.maxbytes = maxbytes
+	cache.evict()
+}
+
+// GetStats returns the hit and miss counters as well as the current size of the ChunkCache.
+func (cache *ChunkCache) GetStats() ChunkCacheStats {
+	stats := cache.stats
+	stats.Count = cache.order.Len()
+	stats.Bytes = cache.bytes
+	return stats
+}
+
+// evict removes the least recently used Chunks until both bounds are met.
+func (cache *ChunkCache) evict() {
+	for cache.order.Len() > 0 &&
+		((cache.maxcount > 0 && cache.order.Len() > cache.maxcount) ||
+			(cache.maxbytes > 0 && cache.bytes > cache.maxbytes)) {
+		cache.remove(cache.order.Back())
+		cache.stats.Evictions++
+	}
+}
+
+// remove deletes the element from the list and the map.
+func (cache *ChunkCache) remove(element *list.Element) {
+	chunk := element.Value.(Chunk)
+	cache.order.Remove(element)
+	delete(cache.entries, chunk.key())
+	cache.bytes -= chunk.byteSize()
+}
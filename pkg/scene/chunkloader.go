@@ -0,0 +1,153 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"context"
+	"sync"
+)
+
+// chunkRequest is the level of detail and coordinate of a Chunk that should be built by the ChunkLoader.
+type chunkRequest struct {
+	lod int32
+	x   int32
+	z   int32
+}
+
+// ChunkLoader builds Chunks asynchronously on a bounded pool of worker goroutines.
+// Requests and results are exchanged over channels such that the GL thread never waits for a Chunk.
+// The number of finished Chunks that are handed back per frame is capped to spread the integration cost over several frames.
+// Request and Collect have to be called from the same goroutine.
+type ChunkLoader struct {
+	cf          *ChunkFactory
+	requests    chan chunkRequest
+	results     chan Chunk
+	pending     map[string]chunkRequest
+	discarded   map[string]bool
+	maxperframe int
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          *sync.WaitGroup
+}
+
+// NewChunkLoader starts workercount goroutines that build Chunks using the ChunkFactory.
+// The queuesize limits the number of outstanding requests.
+// Maxperframe is the maximum number of Chunks returned by a single call to Collect.
+// Cancelling the context ctx or calling Close stops all workers.
+func NewChunkLoader(ctx context.Context, cf *ChunkFactory, workercount, queuesize, maxperframe int) *ChunkLoader {
+	if workercount < 1 {
+		workercount = 1
+	}
+	if maxperframe < 1 {
+		maxperframe = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	loader := &ChunkLoader{
+		cf:          cf,
+		requests:    make(chan chunkRequest, queuesize),
+		results:     make(chan Chunk, queuesize),
+		pending:     map[string]chunkRequest{},
+		discarded:   map[string]bool{},
+		maxperframe: maxperframe,
+		ctx:         ctx,
+		cancel:      cancel,
+		wg:          &sync.WaitGroup{},
+	}
+
+	// start workers
+	for i := 0; i < workercount; i++ {
+		loader.wg.Add(1)
+		go loader.work()
+	}
+
+	return loader
+}
+
+// Request queues the Chunk of level lod at (x,z) for building.
+// Chunks that are already being built are not queued a second time.
+// Returns false if the queue is full, in that case the request has to be repeated later.
+func (loader *ChunkLoader) Request(lod, x, z int32) bool {
+	key := makeLODKey(lod, x, z)
+	if _, ok := loader.pending[key]; ok {
+		return true
+	}
+
+	request := chunkRequest{lod, x, z}
+	select {
+	case loader.requests <- request:
+		loader.pending[key] = request
+		return true
+	default:
+		return false
+	}
+}
+
+// IsPending returns true if the Chunk of level lod at (x,z) has been requested but not collected yet.
+func (loader *ChunkLoader) IsPending(lod, x, z int32) bool {
+	_, ok := loader.pending[makeLODKey(lod, x, z)]
+	return ok
+}
+
+// Collect returns the Chunks that have been finished since the last call.
+// At most maxperframe Chunks are returned, the remaining ones are returned by the next calls.
+func (loader *ChunkLoader) Collect() []Chunk {
+	var chunks []Chunk
+	for len(chunks) < loader.maxperframe {
+		select {
+		case chunk := <-loader.results:
+			key := chunk.key()
+			delete(loader.pending, key)
+			if loader.discarded[key] {
+				delete(loader.discarded, key)
+				continue
+			}
+			chunks = append(chunks, chunk)
+		default:
+			return chunks
+		}
+	}
+	return chunks
+}
+
+// Discard drops the results of all Chunks that are requested but not collected yet.
+// This is necessary if the data the Chunks are built from has changed, they can be requested again after they have been dropped.
+func (loader *ChunkLoader) Discard() {
+	for key := range loader.pending {
+		loader.discarded[key] = true
+	}
+}
+
+// DiscardFunc drops the results of all Chunks that are requested but not collected yet and for which discard returns true.
+// The Chunk passed to discard has not been built yet, thus only its level of detail and coordinate are set.
+func (loader *ChunkLoader) DiscardFunc(discard func(chunk *Chunk) bool) {
+	for key, request := range loader.pending {
+		if discard(&Chunk{lod: request.lod, x: request.x, z: request.z}) {
+			loader.discarded[key] = true
+		}
+	}
+}
+
+// Close stops all workers and waits until they have returned.
+// Chunks that are still in the queue are discarded.
+func (loader *ChunkLoader) Close() {
+	loader.cancel()
+	loader.wg.Wait()
+}
+
+// work builds requested Chunks until the ChunkLoader is closed.
+func (loader *ChunkLoader) work() {
+	defer loader.wg.Done()
+	for {
+		select {
+		case <-loader.ctx.Done():
+			return
+		case request := <-loader.requests:
+			chunk := loader.cf.MakeLODChunk(request.lod, request.x, request.z)
+			select {
+			case loader.results <- chunk:
+			case <-loader.ctx.Done():
+				return
+			}
+		}
+	}
+}
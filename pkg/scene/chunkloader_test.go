@@ -0,0 +1,54 @@
+package scene
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// collectAll collects Chunks from the loader until no request is pending anymore.
+func collectAll(t *testing.T, loader *ChunkLoader) []Chunk {
+	t.Helper()
+	var chunks []Chunk
+	deadline := time.Now().Add(5 * time.Second)
+	for len(loader.pending) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("chunks are still pending after 5 seconds: %v", loader.pending)
+		}
+		chunks = append(chunks, loader.Collect()...)
+		time.Sleep(time.Millisecond)
+	}
+	return chunks
+}
+
+func TestChunkLoaderDiscardFunc(t *testing.T) {
+	terrain := makeQueryTerrain(&planeSource{10, 64, 32}, REPEAT)
+	loader := NewChunkLoader(context.Background(), terrain.cf, 2, 16, 16)
+	defer loader.Close()
+
+	for x := int32(0); x < 4; x++ {
+		if !loader.Request(0, x, 0) {
+			t.Fatalf("request of chunk (%v,0) failed", x)
+		}
+	}
+
+	// only the requests matching the predicate are dropped
+	loader.DiscardFunc(func(chunk *Chunk) bool {
+		return chunk.x >= 2
+	})
+	chunks := collectAll(t, loader)
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks but got %v", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if chunk.x >= 2 {
+			t.Fatalf("expected chunk (%v,%v) to be discarded", chunk.x, chunk.z)
+		}
+	}
+
+	// discarded chunks can be requested again
+	loader.Request(0, 3, 0)
+	if chunks := collectAll(t, loader); len(chunks) != 1 || chunks[0].x != 3 {
+		t.Fatalf("expected chunk (3,0) after requesting it again but got %v chunks", len(chunks))
+	}
+}
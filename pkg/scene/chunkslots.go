@@ -0,0 +1,109 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import "github.com/adrianderstroff/realtime-grass/pkg/engine"
+
+// ChunkSlots is a persistent SSBO on the GPU that is divided into slots of the size of one Chunk.
+// The Tile data of a Chunk is uploaded once into a free slot when the Chunk is loaded
+// and the slot is released again when the Chunk is unloaded.
+// Shaders address a Tile by slot*tilesperchunk + the index of the Tile within the Chunk.
+// The height multipliers of the grass are stored in a second buffer with the same layout and one float per Tile.
+type ChunkSlots struct {
+	buffer        engine.SSBO
+	heights       engine.SSBO
+	tilesperchunk int32
+	capacity      int32
+	free          []int32
+}
+
+// MakeChunkSlots creates a ChunkSlots buffer with room for capacity Chunks of tilesperchunk Tiles each.
+// The tilebytesize is the byte size of the data of one Tile.
+func MakeChunkSlots(tilebytesize int, tilesperchunk, capacity int32) ChunkSlots {
+	slots := ChunkSlots{
+		buffer:        engine.MakeSSBO(tilebytesize, int(tilesperchunk*capacity)),
+		heights:       engine.MakeSSBO(4, int(tilesperchunk*capacity)),
+		tilesperchunk: tilesperchunk,
+		capacity:      0,
+		free:          nil,
+	}
+	slots.grow(capacity)
+	return slots
+}
+
+// Alloc reserves a free slot, uploads the Tile data and grass heights of the Chunk into it and returns the slot index.
+// The most recently freed slot is reused first, the slots that have never been used are handed out from the lowest one.
+// If all slots are in use the capacity is doubled.
+func (slots *ChunkSlots) Alloc(chunk *Chunk) int32 {
+	if len(slots.free) == 0 {
+		slots.Reserve(2*slots.capacity + 1)
+	}
+
+	// pop the most recently freed slot
+	slot := slots.free[len(slots.free)-1]
+	slots.free = slots.free[:len(slots.free)-1]
+
+	slots.Upload(slot, chunk)
+	return slot
+}
+
+// Upload replaces the Tile data and grass heights in slot with the ones of the Chunk.
+func (slots *ChunkSlots) Upload(slot int32, chunk *Chunk) {
+	slots.buffer.UploadArrayInRange(chunk.data, int(slot*slots.tilesperchunk), int(slots.tilesperchunk))
+	slots.heights.UploadArrayInRange(chunk.heights, int(slot*slots.tilesperchunk), int(slots.tilesperchunk))
+}
+
+// Free releases the slot such that it can be used by another Chunk.
+func (slots *ChunkSlots) Free(slot int32) {
+	if slot < 0 {
+		return
+	}
+	slots.free = append(slots.free, slot)
+}
+
+// Reserve makes sure that there is room for at least capacity Chunks.
+// The data of all used slots is kept.
+func (slots *ChunkSlots) Reserve(capacity int32) {
+	if capacity <= slots.capacity {
+		return
+	}
+	slots.buffer.Resize(int(capacity * slots.tilesperchunk))
+	slots.heights.Resize(int(capacity * slots.tilesperchunk))
+	slots.grow(capacity)
+}
+
+// Bind makes the buffer available at the specified position.
+func (slots *ChunkSlots) Bind(pos int32) {
+	slots.buffer.Bind(pos)
+}
+
+// Unbind makes the buffer unavailable for reading and writing.
+func (slots *ChunkSlots) Unbind() {
+	slots.buffer.Unbind()
+}
+
+// BindHeights makes the buffer of the grass heights available at the specified position.
+func (slots *ChunkSlots) BindHeights(pos int32) {
+	slots.heights.Bind(pos)
+}
+
+// UnbindHeights makes the buffer of the grass heights unavailable for reading and writing.
+func (slots *ChunkSlots) UnbindHeights() {
+	slots.heights.Unbind()
+}
+
+// Delete destroys the buffers of the Tile data and the grass heights.
+func (slots *ChunkSlots) Delete() {
+	slots.buffer.Delete()
+	slots.heights.Delete()
+}
+
+// grow adds the slots from the current capacity up to the new capacity to the free slots.
+// They are added below the freed slots in descending order, thus freed slots are reused before new ones and new ones are used from the lowest one.
+func (slots *ChunkSlots) grow(capacity int32) {
+	var added []int32
+	for slot := capacity - 1; slot >= slots.capacity; slot-- {
+		added = append(added, slot)
+	}
+	slots.free = append(added, slots.free...)
+	slots.capacity = capacity
+}
@@ -0,0 +1,83 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	// grassmaxcolliders is the maximum number of Colliders that push the grass each frame, see MAXCOLLIDERS in grass/common.glsl.
+	grassmaxcolliders = 32
+	// grassstoredcolliders is the maximum number of Colliders that are kept until ClearColliders is called.
+	grassstoredcolliders = 8 * grassmaxcolliders
+	// collidersize is the number of floats of a single Collider on the GPU.
+	collidersize = 8
+)
+
+// Collider is a capsule that pushes grass blades away, e.g. a player, a ball or a vehicle.
+// The capsule consists of all points within the radius around the line segment from start to end.
+// A sphere is a capsule whose start equals its end.
+type Collider struct {
+	start  mgl32.Vec3
+	end    mgl32.Vec3
+	radius float32
+}
+
+// MakeSphereCollider constructs a spherical Collider around center with the specified radius.
+func MakeSphereCollider(center mgl32.Vec3, radius float32) Collider {
+	return Collider{
+		start:  center,
+		end:    center,
+		radius: radius,
+	}
+}
+
+// MakeCapsuleCollider constructs a Collider around the line segment from start to end with the specified radius.
+func MakeCapsuleCollider(start, end mgl32.Vec3, radius float32) Collider {
+	return Collider{
+		start:  start,
+		end:    end,
+		radius: radius,
+	}
+}
+
+// calcDistance returns the distance between the point p and the surface of the Collider.
+// The distance is negative for points inside the Collider.
+func (collider *Collider) calcDistance(p mgl32.Vec3) float32 {
+	ab := collider.end.Sub(collider.start)
+	t := float32(0.0)
+	if l := ab.Dot(ab); l > 1e-6 {
+		t = mgl32.Clamp(p.Sub(collider.start).Dot(ab)/l, 0, 1)
+	}
+	closest := collider.start.Add(ab.Mul(t))
+	return p.Sub(closest).Len() - collider.radius
+}
+
+// selectColliders returns at most grassmaxcolliders Colliders packed into 2 vec4 each, see colliders in grass/common.glsl.
+// If there are too many Colliders the ones closest to pos are kept.
+// Colliders with the same distance keep the order in which they were added, thus the selection is deterministic.
+func selectColliders(colliders []Collider, pos mgl32.Vec3) []float32 {
+	selected := make([]Collider, 0, len(colliders))
+	for _, collider := range colliders {
+		if collider.radius > 0 {
+			selected = append(selected, collider)
+		}
+	}
+	if len(selected) > grassmaxcolliders {
+		sort.SliceStable(selected, func(i, j int) bool {
+			return selected[i].calcDistance(pos) < selected[j].calcDistance(pos)
+		})
+		selected = selected[:grassmaxcolliders]
+	}
+
+	data := make([]float32, 0, collidersize*len(selected))
+	for _, c := range selected {
+		data = append(data,
+			c.start.X(), c.start.Y(), c.start.Z(), c.radius,
+			c.end.X(), c.end.Y(), c.end.Z(), 0.0,
+		)
+	}
+	return data
+}
@@ -0,0 +1,47 @@
+package scene
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestAddColliderLimit(t *testing.T) {
+	var grass Grass
+	for i := 0; i < 3*grassstoredcolliders; i++ {
+		grass.AddCollider(MakeSphereCollider(mgl32.Vec3{float32(i), 0, 0}, 1))
+	}
+	if len(grass.colliders) != grassstoredcolliders {
+		t.Fatalf("expected %v stored colliders but got %v", grassstoredcolliders, len(grass.colliders))
+	}
+
+	// the oldest colliders have been replaced
+	first := grass.colliders[0].start.X()
+	last := grass.colliders[len(grass.colliders)-1].start.X()
+	if first != 2*grassstoredcolliders || last != 3*grassstoredcolliders-1 {
+		t.Fatalf("expected the newest colliders but got %v to %v", first, last)
+	}
+
+	grass.ClearColliders()
+	if len(grass.colliders) != 0 {
+		t.Fatalf("expected no colliders after clearing but got %v", len(grass.colliders))
+	}
+}
+
+func TestSelectColliders(t *testing.T) {
+	var colliders []Collider
+	for i := 0; i < 2*grassmaxcolliders; i++ {
+		colliders = append(colliders, MakeSphereCollider(mgl32.Vec3{float32(i), 0, 0}, 1))
+	}
+
+	// only the colliders closest to the position are packed
+	data := selectColliders(colliders, mgl32.Vec3{float32(2 * grassmaxcolliders), 0, 0})
+	if len(data) != grassmaxcolliders*collidersize {
+		t.Fatalf("expected %v colliders but got %v floats", grassmaxcolliders, len(data))
+	}
+	for i := 0; i < grassmaxcolliders; i++ {
+		if x := data[i*collidersize]; x < grassmaxcolliders {
+			t.Fatalf("expected only the closest colliders but got one at %v", x)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"math"
+
+	"github.com/go-gl/glfw/v3.2/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/engine"
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// Ground is the surface the CharacterController walks on.
+// The Terrain is a Ground, but any other surface like a synthetic one can be used as well.
+// An error means the position can't be entered.
+type Ground interface {
+	GetSurface(x, z float32) (SurfaceSample, error)
+}
+
+const (
+	// controllermaxstep is the maximum time step in seconds of one update, longer frames are split into several steps.
+	controllermaxstep = 1.0 / 30.0
+	// controllersnapfactor specifies how far below the feet the ground is still followed while walking downhill
+	// as a multiple of the distance walked in one step.
+	controllersnapfactor = 2.0
+)
+
+// CharacterController moves a CameraFPS over a Ground.
+// In walk mode the feet of the character stay on the Ground while the camera is placed at the eye height above them.
+// Gravity pulls the character down after jumping or walking over an edge.
+// Slopes steeper than maxslope in radians can't be walked up, which is decided by the normal of the Tile plane in front of the character.
+// Sudden height changes of the feet are smoothed out for the camera with the stepsmoothing rate,
+// a rate of 0 disables the smoothing.
+// In fly mode the character moves freely in the view direction but never below the eye height above the Ground.
+type CharacterController struct {
+	camera *engine.CameraFPS
+	ground Ground
+
+	eyeheight     float32
+	walkspeed     float32
+	flyspeed      float32
+	jumpspeed     float32
+	gravity       float32
+	maxslope      float32
+	stepsmoothing float32
+
+	feet     mgl32.Vec3
+	velocity float32
+	eyeY     float32
+	onground bool
+	flying   bool
+
+	forward  bool
+	backward bool
+	left     bool
+	right    bool
+	up       bool
+	down     bool
+}
+
+// MakeCharacterController creates a CharacterController in walk mode that stands on the Ground below the camera.
+// Speeds are specified in units per second and the gravity in units per second squared.
+func MakeCharacterController(camera *engine.CameraFPS, ground Ground, eyeheight, walkspeed, flyspeed, jumpspeed, gravity, maxslope, stepsmoothing float32) CharacterController {
+	controller := CharacterController{
+		camera: camera,
+		ground: ground,
+
+		eyeheight:     eyeheight,
+		walkspeed:     walkspeed,
+		flyspeed:      flyspeed,
+		jumpspeed:     jumpspeed,
+		gravity:       gravity,
+		maxslope:      maxslope,
+		stepsmoothing: stepsmoothing,
+	}
+	controller.SetPos(camera.Pos.Sub(mgl32.Vec3{0, eyeheight, 0}))
+	return controller
+}
+
+// SetPos places the feet of the character at pos.
+// In walk mode the feet are moved onto the Ground if they are below it.
+func (controller *CharacterController) SetPos(pos mgl32.Vec3) {
+	controller.feet = pos
+	controller.velocity = 0
+	controller.onground = false
+	if sample, err := controller.ground.GetSurface(pos.X(), pos.Z()); err == nil && pos.Y() <= sample.Height {
+		controller.feet = mgl32.Vec3{pos.X(), sample.Height, pos.Z()}
+		controller.onground = !controller.flying
+	}
+	controller.eyeY = controller.feet.Y() + controller.eyeheight
+	controller.updateCamera()
+}
+
+// GetPos returns the position of the feet of the character.
+func (controller *CharacterController) GetPos() mgl32.Vec3 {
+	return controller.feet
+}
+
+// IsOnGround returns true if the character is standing on the Ground in walk mode.
+func (controller *CharacterController) IsOnGround() bool {
+	return controller.onground
+}
+
+// IsFlying returns true if the character is in fly mode.
+func (controller *CharacterController) IsFlying() bool {
+	return controller.flying
+}
+
+// SetFlying switches between fly mode and walk mode.
+// When switching to walk mode the character falls down onto the Ground.
+func (controller *CharacterController) SetFlying(flying bool) {
+	controller.flying = flying
+	controller.velocity = 0
+	controller.onground = false
+}
+
+// SetMovement sets the directions the character is moving in, which is usually done by OnKeyPress.
+// Up jumps in walk mode and rises in fly mode while down only sinks in fly mode.
+func (controller *CharacterController) SetMovement(forward, backward, left, right, up, down bool) {
+	controller.forward = forward
+	controller.backward = backward
+	controller.left = left
+	controller.right = right
+	controller.up = up
+	controller.down = down
+}
+
+// Update moves the character by the time dt in seconds that passed since the last update and positions the camera.
+func (controller *CharacterController) Update(dt float32) {
+	// split long frames to keep jumps and falls stable
+	for dt > 0 {
+		step := mathutils.MinF32(dt, controllermaxstep)
+		if controller.flying {
+			controller.fly(step)
+		} else {
+			controller.walk(step)
+		}
+		controller.smoothEye(step)
+		dt -= step
+	}
+	controller.updateCamera()
+}
+
+// walk moves the feet along the Ground and applies gravity.
+func (controller *CharacterController) walk(dt float32) {
+	// move in the x-z plane only
+	forward := controller.camera.Target.Sub(controller.camera.Pos)
+	forward = mgl32.Vec3{forward.X(), 0, forward.Z()}
+	if forward.Len() > 1e-6 {
+		forward = forward.Normalize()
+	}
+	right := mgl32.Vec3{controller.camera.Right.X(), 0, controller.camera.Right.Z()}
+	if right.Len() > 1e-6 {
+		right = right.Normalize()
+	}
+	move := controller.getMoveDir(forward, right, mgl32.Vec3{}).Mul(controller.walkspeed * dt)
+
+	// try the full move first and otherwise slide along the x or z axis
+	moved := false
+	for _, m := range []mgl32.Vec3{move, {move.X(), 0, 0}, {0, 0, move.Z()}} {
+		if m.Len() > 1e-6 && controller.canWalk(m) {
+			controller.feet = controller.feet.Add(m)
+			moved = true
+			break
+		}
+	}
+
+	// jump off the ground
+	if controller.onground && controller.up {
+		controller.velocity = controller.jumpspeed
+		controller.onground = false
+	}
+
+	sample, err := controller.ground.GetSurface(controller.feet.X(), controller.feet.Z())
+	if err != nil {
+		return
+	}
+
+	// stick to the ground when walking downhill instead of falling in tiny jumps
+	if controller.onground {
+		snap := controllersnapfactor * controller.walkspeed * dt
+		if moved && controller.feet.Y()-sample.Height <= snap {
+			controller.feet = mgl32.Vec3{controller.feet.X(), sample.Height, controller.feet.Z()}
+			return
+		}
+		if controller.feet.Y() <= sample.Height {
+			controller.feet = mgl32.Vec3{controller.feet.X(), sample.Height, controller.feet.Z()}
+			return
+		}
+		controller.onground = false
+	}
+
+	// fall and land on the ground
+	controller.velocity -= controller.gravity * dt
+	y := controller.feet.Y() + controller.velocity*dt
+	if y <= sample.Height {
+		y = sample.Height
+		controller.velocity = 0
+		controller.onground = true
+	}
+	controller.feet = mgl32.Vec3{controller.feet.X(), y, controller.feet.Z()}
+}
+
+// canWalk returns true if the feet can be moved by move.
+// The move is blocked if the target can't be entered or if it leads up a slope that is too steep.
+func (controller *CharacterController) canWalk(move mgl32.Vec3) bool {
+	target := controller.feet.Add(move)
+	sample, err := controller.ground.GetSurface(target.X(), target.Z())
+	if err != nil {
+		return false
+	}
+	if sample.Slope <= controller.maxslope {
+		return true
+	}
+
+	// steep slopes can always be walked down or jumped over
+	uphill := mgl32.Vec3{-sample.Normal.X(), 0, -sample.Normal.Z()}
+	return uphill.Dot(move) <= 0 || target.Y() > sample.Height
+}
+
+// fly moves the feet in the view direction without gravity but keeps them above the Ground.
+func (controller *CharacterController) fly(dt float32) {
+	forward := controller.camera.Target.Sub(controller.camera.Pos)
+	if forward.Len() > 1e-6 {
+		forward = forward.Normalize()
+	}
+	move := controller.getMoveDir(forward, controller.camera.Right, mgl32.Vec3{0, 1, 0}).Mul(controller.flyspeed * dt)
+	target := controller.feet.Add(move)
+
+	// positions that can't be entered block the horizontal movement
+	sample, err := controller.ground.GetSurface(target.X(), target.Z())
+	if err != nil {
+		target = mgl32.Vec3{controller.feet.X(), target.Y(), controller.feet.Z()}
+		sample, err = controller.ground.GetSurface(target.X(), target.Z())
+	}
+	if err == nil && target.Y() < sample.Height {
+		target = mgl32.Vec3{target.X(), sample.Height, target.Z()}
+	}
+	controller.feet = target
+}
+
+// getMoveDir returns the normalized sum of all directions the character is moving in.
+func (controller *CharacterController) getMoveDir(forward, right, up mgl32.Vec3) mgl32.Vec3 {
+	var dir mgl32.Vec3
+	if controller.forward {
+		dir = dir.Add(forward)
+	}
+	if controller.backward {
+		dir = dir.Sub(forward)
+	}
+	if controller.right {
+		dir = dir.Add(right)
+	}
+	if controller.left {
+		dir = dir.Sub(right)
+	}
+	if controller.up {
+		dir = dir.Add(up)
+	}
+	if controller.down {
+		dir = dir.Sub(up)
+	}
+	if dir.Len() < 1e-6 {
+		return mgl32.Vec3{}
+	}
+	return dir.Normalize()
+}
+
+// smoothEye lets the eye follow the feet.
+// Only steps on the Ground are smoothed while the eye follows jumps, falls and flights immediately.
+func (controller *CharacterController) smoothEye(dt float32) {
+	target := controller.feet.Y() + controller.eyeheight
+	if !controller.onground || controller.stepsmoothing <= 0 {
+		controller.eyeY = target
+		return
+	}
+	alpha := 1 - float32(math.Exp(float64(-controller.stepsmoothing*dt)))
+	controller.eyeY = mathutils.Interpolate(controller.eyeY, target, alpha)
+
+	// never let the eye lag behind by more than half the eye height
+	controller.eyeY = mgl32.Clamp(controller.eyeY, target-controller.eyeheight/2, target+controller.eyeheight/2)
+}
+
+// updateCamera places the camera at the eye of the character.
+func (controller *CharacterController) updateCamera() {
+	controller.camera.SetPos(mgl32.Vec3{controller.feet.X(), controller.eyeY, controller.feet.Z()})
+}
+
+// OnCursorPosMove is a callback handler that is called every time the cursor moves.
+func (controller *CharacterController) OnCursorPosMove(x, y, dx, dy float64) bool {
+	return controller.camera.OnCursorPosMove(x, y, dx, dy)
+}
+
+// OnMouseButtonPress is a callback handler that is called every time a mouse button is pressed or released.
+func (controller *CharacterController) OnMouseButtonPress(leftPressed, rightPressed bool) bool {
+	return false
+}
+
+// OnMouseScroll is a callback handler that is called every time the mouse wheel moves.
+func (controller *CharacterController) OnMouseScroll(x, y float64) bool {
+	return false
+}
+
+// OnKeyPress is a callback handler that is called every time a keyboard key is pressed.
+// W, A, S and D move the character, space jumps or rises, left shift sinks and F toggles the fly mode.
+func (controller *CharacterController) OnKeyPress(key, action, mods int) bool {
+	if action == int(glfw.Repeat) {
+		return false
+	}
+	pressed := action == int(glfw.Press)
+	switch key {
+	case int(glfw.KeyW):
+		controller.forward = pressed
+	case int(glfw.KeyS):
+		controller.backward = pressed
+	case int(glfw.KeyA):
+		controller.left = pressed
+	case int(glfw.KeyD):
+		controller.right = pressed
+	case int(glfw.KeySpace):
+		controller.up = pressed
+	case int(glfw.KeyLeftShift):
+		controller.down = pressed
+	case int(glfw.KeyF):
+		if pressed {
+			controller.SetFlying(!controller.flying)
+		}
+	default:
+		return false
+	}
+	return true
+}
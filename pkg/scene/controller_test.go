@@ -0,0 +1,121 @@
+package scene
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/engine"
+)
+
+// rampGround is a Ground that is flat at height 0 up to wallx and rises with the gradient steepness in x direction after it.
+// Positions farther than 1000 units from the origin can't be entered.
+type rampGround struct {
+	wallx     float32
+	steepness float32
+}
+
+func (ground *rampGround) GetSurface(x, z float32) (SurfaceSample, error) {
+	if mgl32.Abs(x) > 1000 || mgl32.Abs(z) > 1000 {
+		return SurfaceSample{}, fmt.Errorf("Position (%v,%v) is out of bounds", x, z)
+	}
+	if x < ground.wallx {
+		return SurfaceSample{Height: 0, Normal: mgl32.Vec3{0, 1, 0}}, nil
+	}
+	return SurfaceSample{
+		Height: ground.steepness * (x - ground.wallx),
+		Normal: mgl32.Vec3{-ground.steepness, 1, 0}.Normalize(),
+		Slope:  float32(math.Atan(float64(ground.steepness))),
+	}, nil
+}
+
+// makeTestController creates a CharacterController looking in the positive x direction without step smoothing.
+// The eye height is 2, the walk speed 5, the jump speed 10, the gravity 20 and the character can walk up slopes of 40 degrees.
+func makeTestController(ground Ground, pos mgl32.Vec3) (*engine.CameraFPS, *CharacterController) {
+	camera := engine.MakeCameraFPS(800, 600, pos, 6.0, 45.0, 0.1, 1000.0)
+	controller := MakeCharacterController(&camera, ground, 2, 5, 10, 10, 20, mgl32.DegToRad(40), 0)
+	return &camera, &controller
+}
+
+func TestControllerLanding(t *testing.T) {
+	camera, controller := makeTestController(&rampGround{100, 2}, mgl32.Vec3{0, 12, 0})
+	if controller.IsOnGround() {
+		t.Fatal("expected the character to be in the air")
+	}
+
+	// fall down onto the ground
+	controller.Update(0.5)
+	if controller.IsOnGround() || controller.GetPos().Y() <= 0 {
+		t.Fatalf("expected the character to still fall but it is at %v", controller.GetPos())
+	}
+	controller.Update(2)
+	if !controller.IsOnGround() || controller.GetPos().Y() != 0 {
+		t.Fatalf("expected the character to land at height 0 but it is at %v", controller.GetPos())
+	}
+	if camera.Pos.Y() != 2 {
+		t.Fatalf("expected the camera at the eye height 2 but it is at %v", camera.Pos)
+	}
+}
+
+func TestControllerJumping(t *testing.T) {
+	_, controller := makeTestController(&rampGround{100, 2}, mgl32.Vec3{0, 2, 0})
+	if !controller.IsOnGround() {
+		t.Fatalf("expected the character to stand on the ground but it is at %v", controller.GetPos())
+	}
+
+	// the jump reaches its apex of v²/2g = 2.5 after v/g = 0.5 seconds
+	controller.SetMovement(false, false, false, false, true, false)
+	controller.Update(0.5)
+	controller.SetMovement(false, false, false, false, false, false)
+	if controller.IsOnGround() || mgl32.Abs(controller.GetPos().Y()-2.5) > 0.2 {
+		t.Fatalf("expected the character to be at the apex of 2.5 but it is at %v", controller.GetPos())
+	}
+
+	// land again
+	controller.Update(1)
+	if !controller.IsOnGround() || controller.GetPos().Y() != 0 {
+		t.Fatalf("expected the character to land at height 0 but it is at %v", controller.GetPos())
+	}
+}
+
+func TestControllerSteepSlope(t *testing.T) {
+	// a gradient of 2 is about 63 degrees and can't be walked up
+	_, controller := makeTestController(&rampGround{10, 2}, mgl32.Vec3{8, 2, 0})
+	controller.SetMovement(true, false, false, false, false, false)
+	controller.Update(2)
+	if pos := controller.GetPos(); pos.X() >= 10 || pos.Y() != 0 {
+		t.Fatalf("expected the character to be blocked in front of the slope but it is at %v", pos)
+	}
+
+	// a gradient of 0.5 is about 27 degrees and can be walked up
+	_, controller = makeTestController(&rampGround{10, 0.5}, mgl32.Vec3{8, 2, 0})
+	controller.SetMovement(true, false, false, false, false, false)
+	controller.Update(2)
+	if pos := controller.GetPos(); pos.X() <= 10 || pos.Y() <= 0 {
+		t.Fatalf("expected the character to walk up the slope but it is at %v", pos)
+	}
+}
+
+func TestControllerFlyFloor(t *testing.T) {
+	camera, controller := makeTestController(&rampGround{10, 0.5}, mgl32.Vec3{20, 30, 0})
+	controller.SetFlying(true)
+
+	// sink down without falling below the ground
+	controller.SetMovement(false, false, false, false, false, true)
+	controller.Update(5)
+	if controller.IsOnGround() || controller.GetPos().Y() != 5 {
+		t.Fatalf("expected the character to float at height 5 but it is at %v", controller.GetPos())
+	}
+	if camera.Pos.Y() != 7 {
+		t.Fatalf("expected the camera at the eye height above the ground but it is at %v", camera.Pos)
+	}
+
+	// rise again
+	controller.SetMovement(false, false, false, false, true, false)
+	controller.Update(1)
+	if controller.GetPos().Y() <= 5 {
+		t.Fatalf("expected the character to rise but it is at %v", controller.GetPos())
+	}
+}
@@ -0,0 +1,315 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"math"
+	"math/rand"
+)
+
+// ErosionSettings are the parameters of the erosion of a Heightmap.
+// All heights are normalized, thus a height of 1 is the maximum height of the Heightmap, and distances are measured in pixels.
+//
+// The hydraulic erosion simulates Droplets raindrops that run downhill, pick up sediment and deposit it again once they slow down.
+// Inertia between 0 and 1 specifies how much a droplet keeps its direction instead of following the slope.
+// The sediment a droplet can carry is the height it falls multiplied by its speed, its water and the Capacity,
+// but at least MinCapacity.
+// ErosionRate and DepositionRate specify which fraction of the free or exceeding capacity is eroded or deposited per step.
+// Evaporation is the fraction of water a droplet loses per step and Gravity accelerates the droplets downhill.
+// After Lifetime steps a droplet stops. Radius is the radius of the area a droplet erodes in pixels.
+//
+// The thermal erosion lets material slump down slopes that are steeper than the Talus,
+// which is the maximum height difference between two neighbouring pixels.
+// In each of the ThermalIterations the ThermalRate specifies how much of the exceeding material is moved.
+type ErosionSettings struct {
+	Seed int64
+	// hydraulic erosion
+	Droplets       int
+	Inertia        float32
+	Capacity       float32
+	MinCapacity    float32
+	ErosionRate    float32
+	DepositionRate float32
+	Evaporation    float32
+	Gravity        float32
+	Lifetime       int
+	Radius         int
+	// thermal erosion
+	ThermalIterations int
+	Talus             float32
+	ThermalRate       float32
+}
+
+// MakeErosionSettings returns ErosionSettings with the specified seed and number of droplets
+// while all other parameters are set to values that work well for most heightmaps.
+func MakeErosionSettings(seed int64, droplets int) ErosionSettings {
+	return ErosionSettings{
+		Seed: seed,
+		// hydraulic erosion
+		Droplets:       droplets,
+		Inertia:        0.05,
+		Capacity:       4.0,
+		MinCapacity:    0.01,
+		ErosionRate:    0.3,
+		DepositionRate: 0.3,
+		Evaporation:    0.01,
+		Gravity:        4.0,
+		Lifetime:       30,
+		Radius:         3,
+		// thermal erosion
+		ThermalIterations: 20,
+		Talus:             0.01,
+		ThermalRate:       0.5,
+	}
+}
+
+// erosionGrid is the repeating part of the image data of a Heightmap.
+// Since the last row and column equal the first ones they are not part of the grid and
+// all positions are wrapped around, which keeps the Heightmap seamless.
+type erosionGrid struct {
+	width  int
+	height int
+	values []float32
+}
+
+// Erode applies hydraulic and then thermal erosion to the Heightmap.
+// The same settings always yield the same result.
+// Heights of images of integer formats are clamped between 0 and 1 while floating point images keep all heights.
+// Chunks that have been built before are not updated, thus erosion should happen before the Terrain is created.
+func (heightmap *Heightmap) Erode(settings ErosionSettings) {
+	heightmap.lock.Lock()
+	defer heightmap.lock.Unlock()
+
+	// copy the repeating part of the image
+	width := heightmap.data.GetWidth()
+	height := heightmap.data.GetHeight()
+	if width < 2 || height < 2 {
+		return
+	}
+	grid := erosionGrid{int(width - 1), int(height - 1), make([]float32, (width-1)*(height-1))}
+	for z := 0; z < grid.height; z++ {
+		for x := 0; x < grid.width; x++ {
+			grid.values[z*grid.width+x] = heightmap.getHeightValue(int32(x), int32(z))
+		}
+	}
+
+	// erode
+	random := rand.New(rand.NewSource(settings.Seed))
+	grid.erodeHydraulic(settings, random)
+	grid.erodeThermal(settings)
+
+	// write back and keep the last row and column equal to the first ones
+	for z := 0; z < grid.height; z++ {
+		for x := 0; x < grid.width; x++ {
+			heightmap.setHeightValue(int32(x), int32(z), heightmap.clampValue(grid.values[z*grid.width+x]))
+		}
+	}
+	for x := int32(0); x < width; x++ {
+		heightmap.setHeightValue(x, height-1, heightmap.getHeightValue(x%(width-1), 0))
+	}
+	for z := int32(0); z < height; z++ {
+		heightmap.setHeightValue(width-1, z, heightmap.getHeightValue(0, z%(height-1)))
+	}
+}
+
+// erodeHydraulic simulates the droplets one after another.
+func (grid *erosionGrid) erodeHydraulic(settings ErosionSettings, random *rand.Rand) {
+	brush := makeErosionBrush(settings.Radius)
+	for i := 0; i < settings.Droplets; i++ {
+		// start at a random position
+		px := random.Float32() * float32(grid.width)
+		pz := random.Float32() * float32(grid.height)
+		var dirx, dirz float32
+		var speed, water, sediment float32 = 1.0, 1.0, 0.0
+
+		for step := 0; step < settings.Lifetime; step++ {
+			ix := int(math.Floor(float64(px)))
+			iz := int(math.Floor(float64(pz)))
+			ax := px - float32(ix)
+			az := pz - float32(iz)
+
+			// follow the slope while keeping some of the old direction
+			h, gx, gz := grid.calcHeightAndGradient(px, pz)
+			dirx = dirx*settings.Inertia - gx*(1-settings.Inertia)
+			dirz = dirz*settings.Inertia - gz*(1-settings.Inertia)
+			length := float32(math.Sqrt(float64(dirx*dirx + dirz*dirz)))
+			if length < 1e-6 {
+				// flat ground, move in a random direction
+				angle := random.Float64() * 2 * math.Pi
+				dirx = float32(math.Cos(angle))
+				dirz = float32(math.Sin(angle))
+			} else {
+				dirx /= length
+				dirz /= length
+			}
+			px += dirx
+			pz += dirz
+
+			// the capacity grows with the speed and the height difference
+			newh, _, _ := grid.calcHeightAndGradient(px, pz)
+			dh := newh - h
+			capacity := -dh * speed * water * settings.Capacity
+			if capacity < settings.MinCapacity {
+				capacity = settings.MinCapacity
+			}
+
+			if sediment > capacity || dh > 0 {
+				// fill up the pit when moving uphill, otherwise drop the exceeding sediment
+				var amount float32
+				if dh > 0 {
+					amount = float32(math.Min(float64(dh), float64(sediment)))
+				} else {
+					amount = (sediment - capacity) * settings.DepositionRate
+				}
+				sediment -= amount
+				grid.deposit(ix, iz, ax, az, amount)
+			} else {
+				// erode at most the height difference to not dig holes
+				amount := float32(math.Min(float64((capacity-sediment)*settings.ErosionRate), float64(-dh)))
+				sediment += grid.erode(ix, iz, amount, brush)
+			}
+
+			speed = float32(math.Sqrt(math.Max(0, float64(speed*speed-dh*settings.Gravity))))
+			water *= 1 - settings.Evaporation
+		}
+	}
+}
+
+// erodeThermal moves material from pixels to their lower neighbours as long as the height difference exceeds the talus.
+// All pixels are updated at once per iteration such that the result does not depend on the order of the pixels.
+func (grid *erosionGrid) erodeThermal(settings ErosionSettings) {
+	// 8 neighbours with the talus scaled by their distance
+	offsets := [8][2]int{{-1, -1}, {0, -1}, {1, -1}, {-1, 0}, {1, 0}, {-1, 1}, {0, 1}, {1, 1}}
+	var taluses [8]float32
+	for n, o := range offsets {
+		taluses[n] = settings.Talus * float32(math.Sqrt(float64(o[0]*o[0]+o[1]*o[1])))
+	}
+
+	delta := make([]float32, len(grid.values))
+	var excess [8]float32
+	for it := 0; it < settings.ThermalIterations; it++ {
+		for i := range delta {
+			delta[i] = 0
+		}
+		for z := 0; z < grid.height; z++ {
+			for x := 0; x < grid.width; x++ {
+				h := grid.get(x, z)
+
+				// collect how much each neighbour is below the talus
+				var sum, maxexcess float32
+				for n, o := range offsets {
+					excess[n] = h - grid.get(x+o[0], z+o[1]) - taluses[n]
+					if excess[n] <= 0 {
+						excess[n] = 0
+						continue
+					}
+					sum += excess[n]
+					if excess[n] > maxexcess {
+						maxexcess = excess[n]
+					}
+				}
+				if sum == 0 {
+					continue
+				}
+
+				// move half of the largest excess to not overshoot and distribute it by the excess of each neighbour
+				amount := settings.ThermalRate * maxexcess / 2
+				delta[z*grid.width+x] -= amount
+				for n, o := range offsets {
+					if excess[n] > 0 {
+						delta[grid.index(x+o[0], z+o[1])] += amount * excess[n] / sum
+					}
+				}
+			}
+		}
+		for i := range grid.values {
+			grid.values[i] += delta[i]
+		}
+	}
+}
+
+// calcHeightAndGradient returns the bilinear height and the gradient at the sub-pixel position (x,z).
+func (grid *erosionGrid) calcHeightAndGradient(x, z float32) (float32, float32, float32) {
+	ix := int(math.Floor(float64(x)))
+	iz := int(math.Floor(float64(z)))
+	ax := x - float32(ix)
+	az := z - float32(iz)
+
+	h00 := grid.get(ix, iz)
+	h10 := grid.get(ix+1, iz)
+	h01 := grid.get(ix, iz+1)
+	h11 := grid.get(ix+1, iz+1)
+
+	gx := (h10-h00)*(1-az) + (h11-h01)*az
+	gz := (h01-h00)*(1-ax) + (h11-h10)*ax
+	h := h00*(1-ax)*(1-az) + h10*ax*(1-az) + h01*(1-ax)*az + h11*ax*az
+	return h, gx, gz
+}
+
+// deposit adds the amount of sediment to the 4 pixels around the sub-pixel position weighted by their distance.
+func (grid *erosionGrid) deposit(ix, iz int, ax, az, amount float32) {
+	grid.values[grid.index(ix, iz)] += amount * (1 - ax) * (1 - az)
+	grid.values[grid.index(ix+1, iz)] += amount * ax * (1 - az)
+	grid.values[grid.index(ix, iz+1)] += amount * (1 - ax) * az
+	grid.values[grid.index(ix+1, iz+1)] += amount * ax * az
+}
+
+// erode removes up to the amount of material within the brush around the pixel (ix,iz) and returns the removed amount.
+func (grid *erosionGrid) erode(ix, iz int, amount float32, brush []erosionBrushSample) float32 {
+	var removed float32
+	for _, sample := range brush {
+		idx := grid.index(ix+sample.dx, iz+sample.dz)
+		delta := float32(math.Min(float64(amount*sample.weight), float64(grid.values[idx])))
+		grid.values[idx] -= delta
+		removed += delta
+	}
+	return removed
+}
+
+// get returns the value at the pixel (x,z) with the position wrapped around.
+func (grid *erosionGrid) get(x, z int) float32 {
+	return grid.values[grid.index(x, z)]
+}
+
+// index returns the index of the pixel (x,z) with the position wrapped around.
+func (grid *erosionGrid) index(x, z int) int {
+	x %= grid.width
+	if x < 0 {
+		x += grid.width
+	}
+	z %= grid.height
+	if z < 0 {
+		z += grid.height
+	}
+	return z*grid.width + x
+}
+
+// erosionBrushSample is the offset and weight of one pixel of the area a droplet erodes.
+type erosionBrushSample struct {
+	dx, dz int
+	weight float32
+}
+
+// makeErosionBrush returns the pixels within the radius with weights that fall off linearly and sum up to 1.
+func makeErosionBrush(radius int) []erosionBrushSample {
+	if radius < 1 {
+		return []erosionBrushSample{{0, 0, 1}}
+	}
+
+	var brush []erosionBrushSample
+	var sum float32
+	for dz := -radius; dz <= radius; dz++ {
+		for dx := -radius; dx <= radius; dx++ {
+			dist := float32(math.Sqrt(float64(dx*dx + dz*dz)))
+			if dist >= float32(radius) {
+				continue
+			}
+			weight := 1 - dist/float32(radius)
+			brush = append(brush, erosionBrushSample{dx, dz, weight})
+			sum += weight
+		}
+	}
+	for i := range brush {
+		brush[i].weight /= sum
+	}
+	return brush
+}
@@ -0,0 +1,65 @@
+package scene
+
+import "testing"
+
+// erodeRamp erodes a ramp Heightmap whose heights are scaled by scale and returns the eroded Heightmap.
+func erodeRamp(seed int64, scale float32) Heightmap {
+	heightmap := makeRampHeightmap(32)
+	width := heightmap.GetWidth()
+	height := heightmap.GetHeight()
+	for z := int32(0); z < height; z++ {
+		for x := int32(0); x < width; x++ {
+			heightmap.setHeightValue(x, z, scale*heightmap.getHeightValue(x, z))
+		}
+	}
+	heightmap.Erode(MakeErosionSettings(seed, 500))
+	return heightmap
+}
+
+// countDifferences returns the number of pixels in which both Heightmaps differ.
+func countDifferences(a, b *Heightmap) int {
+	count := 0
+	for z := int32(0); z < a.GetHeight(); z++ {
+		for x := int32(0); x < a.GetWidth(); x++ {
+			if a.getHeightValue(x, z) != b.getHeightValue(x, z) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func TestErodeDeterministic(t *testing.T) {
+	a := erodeRamp(42, 1)
+	b := erodeRamp(42, 1)
+	if diff := countDifferences(&a, &b); diff != 0 {
+		t.Fatalf("expected the same seed to yield the same heights but %v pixels differ", diff)
+	}
+
+	c := erodeRamp(43, 1)
+	if countDifferences(&a, &c) == 0 {
+		t.Fatal("expected a different seed to yield different heights")
+	}
+
+	// the eroded Heightmap stays seamless
+	for z := int32(0); z < a.GetHeight(); z++ {
+		if a.getHeightValue(0, z) != a.getHeightValue(a.GetWidth()-1, z) {
+			t.Fatalf("expected the last column to equal the first one in row %v", z)
+		}
+	}
+}
+
+func TestErodeFloatNotClamped(t *testing.T) {
+	heightmap := erodeRamp(42, 8)
+	var maxval float32
+	for z := int32(0); z < heightmap.GetHeight(); z++ {
+		for x := int32(0); x < heightmap.GetWidth(); x++ {
+			if val := heightmap.getHeightValue(x, z); val > maxval {
+				maxval = val
+			}
+		}
+	}
+	if maxval <= 1 {
+		t.Fatalf("expected floating point heights above 1 to be kept but the maximum is %v", maxval)
+	}
+}
@@ -0,0 +1,269 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/engine"
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// ExportFormat is the file format of an exported mesh.
+type ExportFormat int
+
+const (
+	OBJ ExportFormat = iota
+	PLY
+	GLTF
+)
+
+const (
+	// exportmaxtiles is the maximum number of Tiles of an exported world rectangle.
+	exportmaxtiles = 1 << 22
+)
+
+// exportTile is the Tile data of one Tile together with the side length of the Tile.
+type exportTile struct {
+	data []float32
+	size float32
+}
+
+// Export writes the currently loaded Chunks as triangle mesh into the file at path.
+// Chunks that are hidden behind a coarser Chunk are skipped such that no Tiles overlap.
+// The Chunks are sorted by their key such that the same loaded Chunks always yield the same file.
+// With withgrass the grass blades of all Tiles of level 0 are written as separate object.
+func (terrain *Terrain) Export(path string, format ExportFormat, withgrass bool) error {
+	keys := make([]string, 0, len(terrain.chunks))
+	for key := range terrain.chunks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var tiles []exportTile
+	for _, key := range keys {
+		chunk := terrain.chunks[key]
+		if terrain.isHidden(chunk) {
+			continue
+		}
+		size := terrain.tilesize * float32(int32(1)<<uint(chunk.lod))
+		for i := 0; i+12 <= len(chunk.data); i += 12 {
+			tiles = append(tiles, exportTile{chunk.data[i : i+12], size})
+		}
+	}
+
+	return terrain.export(path, format, tiles, withgrass)
+}
+
+// ExportRect writes all Tiles of level 0 that overlap the world rectangle from (minx,minz) to (maxx,maxz) as triangle mesh into the file at path.
+// The Tiles are created by the TileFactory, thus the rectangle does not have to be loaded.
+// With withgrass the grass blades of all Tiles are written as separate object.
+func (terrain *Terrain) ExportRect(path string, format ExportFormat, minx, minz, maxx, maxz float32, withgrass bool) error {
+	if !isFinite(minx) || !isFinite(minz) || !isFinite(maxx) || !isFinite(maxz) || minx >= maxx || minz >= maxz {
+		return fmt.Errorf("Invalid export rectangle (%v,%v) to (%v,%v)", minx, minz, maxx, maxz)
+	}
+
+	// range of tiles overlapping the rectangle
+	ts := terrain.tilesize
+	gx0 := int32(mathutils.FloorF32(minx / ts))
+	gz0 := int32(mathutils.FloorF32(minz / ts))
+	gx1 := int32(mathutils.CeilF32(maxx / ts))
+	gz1 := int32(mathutils.CeilF32(maxz / ts))
+	if int64(gx1-gx0)*int64(gz1-gz0) > exportmaxtiles {
+		return fmt.Errorf("Export rectangle contains more than %v tiles", exportmaxtiles)
+	}
+
+	var tiles []exportTile
+	for gz := gz0; gz < gz1; gz++ {
+		for gx := gx0; gx < gx1; gx++ {
+			// skip tiles outside of a finite terrain
+			if !terrain.IsInBounds((float32(gx)+0.5)*ts, (float32(gz)+0.5)*ts) {
+				continue
+			}
+			tile := terrain.tf.MakeTile(gx, gz)
+			tiles = append(tiles, exportTile{tile.data, ts})
+		}
+	}
+
+	return terrain.export(path, format, tiles, withgrass)
+}
+
+// export builds the meshes of the Tiles and writes them in the specified format.
+func (terrain *Terrain) export(path string, format ExportFormat, tiles []exportTile, withgrass bool) error {
+	meshes := []engine.MeshData{terrain.makeTerrainMesh(tiles)}
+	if withgrass {
+		meshes = append(meshes, terrain.makeGrassMesh(tiles))
+	}
+
+	switch format {
+	case OBJ:
+		return engine.SaveObj(path, meshes)
+	case PLY:
+		return engine.SavePly(path, meshes)
+	case GLTF:
+		return engine.SaveGltf(path, meshes)
+	default:
+		return fmt.Errorf("Unknown export format %v", format)
+	}
+}
+
+// makeTerrainMesh creates two triangles per Tile in the same way as the terrain shader.
+// Each triangle gets the normal of its plane and the texture coordinates are the position in block space.
+func (terrain *Terrain) makeTerrainMesh(tiles []exportTile) engine.MeshData {
+	mesh := engine.MeshData{Name: "terrain"}
+	for _, tile := range tiles {
+		d := tile.data
+		tri1 := mgl32.Vec4{d[0], d[1], d[2], d[3]}
+		tri2 := mgl32.Vec4{d[4], d[5], d[6], d[7]}
+		half := tile.size / 2
+
+		// four corners of the Tile
+		p1 := calcPlanePoint(tri1, d[8]-half, d[9]+half)
+		p2 := calcPlanePoint(tri1, d[8]-half, d[9]-half)
+		p3 := calcPlanePoint(tri1, d[8]+half, d[9]+half)
+		p4 := calcPlanePoint(tri2, d[8]+half, d[9]-half)
+
+		// the triangles 1-2-3 and 3-2-4 are reversed to be counter-clockwise when seen from above
+		terrain.addTriangle(&mesh, p1, p3, p2, upwards(tri1.Vec3()))
+		terrain.addTriangle(&mesh, p3, p4, p2, upwards(tri2.Vec3()))
+	}
+	return mesh
+}
+
+// addTriangle appends a triangle with the normal n to the mesh.
+func (terrain *Terrain) addTriangle(mesh *engine.MeshData, a, b, c, n mgl32.Vec3) {
+	for _, p := range []mgl32.Vec3{a, b, c} {
+		mesh.Indices = append(mesh.Indices, uint32(len(mesh.Vertices)/3))
+		mesh.Vertices = append(mesh.Vertices, p.X(), p.Y(), p.Z())
+		mesh.Normals = append(mesh.Normals, n.X(), n.Y(), n.Z())
+		mesh.Texcoords = append(mesh.Texcoords, p.X()/terrain.blocksize, p.Z()/terrain.blocksize)
+	}
+}
+
+// makeGrassMesh creates the grass blades of all Tiles of level 0 at full detail and without wind.
+// The root positions, species, heights and widths of the blades are calculated the same way as in the grass shader.
+// Since the shader turns the blades towards the camera each exported blade is rotated randomly around the y-axis instead.
+// Cut grass is exported at its cut height.
+// The blades narrow towards the tip as the alpha texture of the shader is not exported.
+func (terrain *Terrain) makeGrassMesh(tiles []exportTile) engine.MeshData {
+	mesh := engine.MeshData{Name: "grass"}
+	positions := terrain.grass.positions
+	bladecount := len(positions) / 2
+
+	for _, tile := range tiles {
+		d := tile.data
+		material, density, submerged := unpackMaterial(d[11])
+		cut := terrain.grass.cuts.GetHeight(d[8], d[9])
+		if d[10] > 0 || material == PATH || submerged || density <= 0 || cut <= 0 {
+			continue
+		}
+		tri1 := mgl32.Vec4{d[0], d[1], d[2], d[3]}
+		tri2 := mgl32.Vec4{d[4], d[5], d[6], d[7]}
+
+		for vid := 0; vid < bladecount; vid++ {
+			if float32(vid) >= density*float32(bladecount) {
+				break
+			}
+
+			// random number and root position of the blade
+			px, pz := positions[2*vid], positions[2*vid+1]
+			r := calcBladeRand(d[8], d[9], px, pz)
+			lx, lz := getRootLocalPos(px, pz, r)
+			root := calcRootWorldPos(tri1, tri2, d[8], d[9], tile.size, lx, lz)
+
+			// blade dimensions
+			species := terrain.grass.getBladeSpecies(unpackMix(d[11]), r)
+			height := calcRange(species.MinHeight, species.MaxHeight, r) * getMaterialHeight(material) * cut
+			width := calcRange(species.MinWidth, species.MaxWidth, r)
+			angle := float64(r) * math.Pi
+			right := mgl32.Vec3{float32(math.Cos(angle)), 0, float32(math.Sin(angle))}.Mul(width)
+
+			addBlade(&mesh, root, right, height, clampSegments(species.Segments))
+		}
+	}
+	return mesh
+}
+
+// addBlade appends a grass blade consisting of several segments that narrow towards the tip.
+func addBlade(mesh *engine.MeshData, root, right mgl32.Vec3, height float32, segments int) {
+	n := right.Cross(mgl32.Vec3{0, 1, 0}).Normalize()
+	base := uint32(len(mesh.Vertices) / 3)
+	for s := 0; s <= segments; s++ {
+		t := float32(s) / float32(segments)
+		center := root.Add(mgl32.Vec3{0, t * height, 0})
+		w := right.Mul(1 - t)
+		for _, p := range []mgl32.Vec3{center.Sub(w), center.Add(w)} {
+			mesh.Vertices = append(mesh.Vertices, p.X(), p.Y(), p.Z())
+			mesh.Normals = append(mesh.Normals, n.X(), n.Y(), n.Z())
+		}
+		mesh.Texcoords = append(mesh.Texcoords, 0, 1-t, 1, 1-t)
+	}
+	for s := uint32(0); s < uint32(segments); s++ {
+		i := base + 2*s
+		mesh.Indices = append(mesh.Indices, i, i+1, i+3, i, i+3, i+2)
+	}
+}
+
+// calcBladeRand returns the random number of the blade at the random position (px,pz) of the Tile at (x,z),
+// see rand in grass/common.glsl.
+func calcBladeRand(x, z, px, pz float32) float32 {
+	f := float64(x * z)
+	return float32(math.Sin(f*math.Pi/2*fract(float64(px)) + f*math.Pi/2*fract(float64(pz))))
+}
+
+// getRootLocalPos returns the root position of the blade relative to the Tile center in the range from -0.5 to 0.5,
+// see getRootLocalPos in grass/common.glsl.
+func getRootLocalPos(px, pz, r float32) (float32, float32) {
+	return float32(fract(float64(px+r)) - 0.5), float32(fract(float64(pz+r)) - 0.5)
+}
+
+// calcRootWorldPos returns the root position of the blade on one of the triangle planes of the Tile at (x,z),
+// see calcRootWorldPos in grass/common.glsl.
+func calcRootWorldPos(tri1, tri2 mgl32.Vec4, x, z, size, lx, lz float32) mgl32.Vec3 {
+	lx *= size
+	lz *= size
+	plane := tri2
+	if lx < lz {
+		plane = tri1
+	}
+	return calcPlanePoint(plane, x+lx, z+lz)
+}
+
+// getMaterialHeight returns the height factor of the grass growing on the Material, see grass/common.glsl.
+func getMaterialHeight(material Material) float32 {
+	switch material {
+	case DIRT:
+		return 0.6
+	case ROCK:
+		return 0.4
+	default:
+		return 1.0
+	}
+}
+
+// calcPlanePoint returns the point on the plane at the position (x,z).
+func calcPlanePoint(plane mgl32.Vec4, x, z float32) mgl32.Vec3 {
+	y := -(plane.W() + plane.X()*x + plane.Z()*z) / plane.Y()
+	return mgl32.Vec3{x, y, z}
+}
+
+// upwards flips the normal n if it is pointing downwards.
+func upwards(n mgl32.Vec3) mgl32.Vec3 {
+	if n.Y() < 0 {
+		return n.Mul(-1)
+	}
+	return n
+}
+
+// calcRange maps the random number r onto the range from min to max, see range in grass/common.glsl.
+func calcRange(min, max, r float32) float32 {
+	return (max-min)*r + min
+}
+
+// fract returns the fractional part of x which is always positive like in GLSL.
+func fract(x float64) float64 {
+	return x - math.Floor(x)
+}
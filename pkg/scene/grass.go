@@ -2,6 +2,7 @@
 package scene
 
 import (
+	"fmt"
 	"math/rand"
 
 	"github.com/go-gl/gl/v4.3-core/gl"
@@ -11,19 +12,33 @@ import (
 )
 
 // Grass renders individual grass blades on every Tile of the Terrain.
+// Each blade belongs to one of the GrassSpecies.
+// Which GrassSpecies grow on a Tile is decided by the species mix the Tile has been assigned by the Splatmap.
+// The grass blades bend away from all Colliders that had been added since the last call of ClearColliders.
+// The height of the grass on each Tile is scaled by the height multiplier of the GrassCuts.
+// The blades are either generated in a geometry shader or culled in a compute shader and drawn indirectly, see GrassPipeline.
 type Grass struct {
-	shader        engine.ShaderProgram
-	buffer        engine.Mesh
-	grassAlpha    engine.Texture
-	grassDiffuse0 engine.Texture
-	grassDiffuse1 engine.Texture
-	grassDiffuse2 engine.Texture
-	grassDiffuse3 engine.Texture
-	bladecount    int32
-	height        float32
-	viewdist      float32
-	time          float32
-	windradius    int32
+	shader         engine.ShaderProgram
+	buffer         engine.Mesh
+	positions      []float32
+	grassAlpha     engine.Texture
+	grassCard      engine.Texture
+	grassDiffuse   []engine.Texture
+	species        []GrassSpecies
+	speciesbuffer  engine.SSBO
+	mixes          [grassmaxmixes][grassmaxspecies]float32
+	mixbuffer      engine.SSBO
+	bladecount     int32
+	height         float32
+	viewdist       float32
+	time           float32
+	windradius     int32
+	colliders      []Collider
+	colliderbuffer engine.SSBO
+	collidercount  int32
+	cuts           *GrassCuts
+	pipeline       GrassPipeline
+	compute        grassCompute
 }
 
 // MakeGrass constructs the Grass entity.
@@ -31,7 +46,23 @@ type Grass struct {
 // Height is the maximum height of the grass blades.
 // The viewdist is the far value of the camera.
 // The windradius is the radius of the Wind grid.
-func MakeGrass(shaderpath, texpath string, bladecount int, height, viewdist float32, windradius int32) (Grass, error) {
+// The seed decides the root positions of the grass blades, thus the same seed always yields the same grass.
+// Up to 8 species can be specified, without any species the default species is used.
+// Initially every species mix contains all species equally.
+// The cuts store how far the grass on each Tile has been cut and are shared with the ChunkFactory.
+func MakeGrass(shaderpath, texpath string, bladecount int, height, viewdist float32, windradius int32, seed int64, species []GrassSpecies, cuts *GrassCuts) (Grass, error) {
+	// validate species
+	if len(species) == 0 {
+		species = []GrassSpecies{MakeDefaultGrassSpecies()}
+	}
+	if len(species) > grassmaxspecies {
+		return Grass{}, fmt.Errorf("At most %v grass species are supported but %v were specified", grassmaxspecies, len(species))
+	}
+	texturepaths, textureids, err := collectSpeciesTextures(species)
+	if err != nil {
+		return Grass{}, err
+	}
+
 	// make shader
 	shader, err := engine.MakeGeomProgram(shaderpath+"/grass/grass.vert", shaderpath+"/grass/grass.geom", shaderpath+"/grass/grass.frag")
 	if err != nil {
@@ -39,9 +70,10 @@ func MakeGrass(shaderpath, texpath string, bladecount int, height, viewdist floa
 	}
 
 	// generate random 2D root positions
+	random := rand.New(rand.NewSource(seed))
 	var positions []float32
 	for i := 0; i < bladecount; i++ {
-		positions = append(positions, rand.Float32(), rand.Float32())
+		positions = append(positions, random.Float32(), random.Float32())
 	}
 
 	// generate buffer
@@ -53,87 +85,249 @@ func MakeGrass(shaderpath, texpath string, bladecount int, height, viewdist floa
 	mesh.SetVAO(vao)
 	shader.AddRenderable(mesh)
 
-	// load grass texture
-	grassalpha, err := engine.MakeTextureFromPath(texpath + "grassAlpha.png")
+	// make culling compute shader and indirectly drawn blades
+	compute, err := makeGrassCompute(shaderpath, positions)
 	if err != nil {
 		return Grass{}, err
 	}
-	grassdiffuse0, err := engine.MakeTextureFromPath(texpath + "grass0.jpg")
-	if err != nil {
-		return Grass{}, err
-	}
-	grassdiffuse1, err := engine.MakeTextureFromPath(texpath + "grass1.jpg")
+
+	// load grass texture
+	grassalpha, err := engine.MakeTextureFromPath(texpath + "grassAlpha.png")
 	if err != nil {
 		return Grass{}, err
 	}
-	grassdiffuse2, err := engine.MakeTextureFromPath(texpath + "grass2.jpg")
+	grasscard, err := engine.MakeTextureFromPath(texpath + "grass0.jpg")
 	if err != nil {
 		return Grass{}, err
 	}
-	grassdiffuse3, err := engine.MakeTextureFromPath(texpath + "grass3.jpg")
-	if err != nil {
-		return Grass{}, err
+	// load the textures of all species
+	var grassdiffuse []engine.Texture
+	for _, path := range texturepaths {
+		texture, err := engine.MakeTextureFromPath(texpath + path)
+		if err != nil {
+			return Grass{}, err
+		}
+		texture.GenMipmap()
+		grassdiffuse = append(grassdiffuse, texture)
 	}
 	// generate mipmaps
 	grassalpha.GenMipmap()
-	grassdiffuse0.GenMipmapNearest()
-	grassdiffuse1.GenMipmap()
-	grassdiffuse2.GenMipmap()
-	grassdiffuse3.GenMipmap()
+	grasscard.GenMipmapNearest()
+
+	// upload species
+	speciesbuffer := engine.MakeSSBO(grassspeciessize*4, len(species))
+	speciesbuffer.UploadArray(makeSpeciesData(species, textureids))
 
-	return Grass{
+	// mix all species equally in every mix
+	var mixes [grassmaxmixes][grassmaxspecies]float32
+	for m := range mixes {
+		mixes[m] = makeSpeciesMix(nil, len(species))
+	}
+	mixbuffer := engine.MakeSSBO(4, grassmaxmixes*grassmaxspecies)
+
+	// setup ssbo with start, radius and end of all colliders
+	colliderbuffer := engine.MakeSSBO(collidersize*4, grassmaxcolliders)
+
+	grass := Grass{
 		shader,
 		mesh,
+		positions,
 		grassalpha,
-		grassdiffuse0,
-		grassdiffuse1,
-		grassdiffuse2,
-		grassdiffuse3,
+		grasscard,
+		grassdiffuse,
+		species,
+		speciesbuffer,
+		mixes,
+		mixbuffer,
 		int32(bladecount),
 		height,
 		viewdist,
 		0.0,
 		windradius,
-	}, nil
+		nil,
+		colliderbuffer,
+		0,
+		cuts,
+		GEOMETRY,
+		compute,
+	}
+	grass.uploadMixes()
+
+	return grass, nil
 }
 
-// Render draws all grass blades using a LOD approach.
-func (grass *Grass) Render(instancecount int32, tilesize float32, M, V, P mgl32.Mat4, camerapos mgl32.Vec3) {
-	lightdir := mgl32.Vec3{10.0, 0.0, 10.0}
-	lightcolor := mgl32.Vec3{1.0, 1.0, 0.0}
+// SetSpeciesMix specifies how often each GrassSpecies grows on Tiles that use the species mix with the index mix.
+// The weights are relative to each other and are specified in the order of the GrassSpecies, missing weights are 0.
+// Without any positive weight all GrassSpecies are mixed equally.
+func (grass *Grass) SetSpeciesMix(mix int32, weights ...float32) error {
+	if mix < 0 || mix >= grassmaxmixes {
+		return fmt.Errorf("Species mix %v is not between 0 and %v", mix, grassmaxmixes-1)
+	}
+	if len(weights) > len(grass.species) {
+		return fmt.Errorf("Got %v weights for %v grass species", len(weights), len(grass.species))
+	}
+	grass.mixes[mix] = makeSpeciesMix(weights, len(grass.species))
+	grass.uploadMixes()
+	return nil
+}
+
+// AddCollider adds a Collider that pushes the grass blades away.
+// Colliders have to be added again each frame after calling ClearColliders.
+// At most 32 Colliders are taken into account, if there are more the ones closest to the camera are used.
+// At most 256 Colliders are kept, once there are that many the oldest one is replaced,
+// thus forgetting to call ClearColliders doesn't let the Colliders pile up.
+func (grass *Grass) AddCollider(collider Collider) {
+	if len(grass.colliders) >= grassstoredcolliders {
+		// drop the oldest collider
+		copy(grass.colliders, grass.colliders[1:])
+		grass.colliders = grass.colliders[:len(grass.colliders)-1]
+	}
+	grass.colliders = append(grass.colliders, collider)
+}
+
+// ClearColliders removes all Colliders.
+func (grass *Grass) ClearColliders() {
+	grass.colliders = grass.colliders[:0]
+}
+
+// Cut lowers the grass within the CutArea to height times its full height, where height is between 0 and 1.
+// Grass that is already shorter is not changed.
+// Only Chunks that are created afterwards show the cut grass, see Terrain.CutGrass for updating the loaded Chunks.
+// Returns false if no Tile has been changed.
+func (grass *Grass) Cut(area CutArea, height float32) bool {
+	return grass.cuts.Cut(area, height)
+}
+
+// SetPipeline switches the GrassPipeline that is used for rendering the grass.
+// If the GPU doesn't support the COMPUTE GrassPipeline an error is returned and the current GrassPipeline is kept.
+func (grass *Grass) SetPipeline(pipeline GrassPipeline) error {
+	if pipeline == COMPUTE {
+		if err := grass.compute.checkSupport(); err != nil {
+			return err
+		}
+	}
+	grass.pipeline = pipeline
+	return nil
+}
+
+// GetPipeline returns the GrassPipeline that is used for rendering the grass.
+func (grass *Grass) GetPipeline() GrassPipeline {
+	return grass.pipeline
+}
+
+// getBladeSpecies returns the GrassSpecies of a blade with the random number r on a Tile using the species mix with the index mix,
+// see getSpecies in grass/common.glsl.
+func (grass *Grass) getBladeSpecies(mixidx int32, r float32) GrassSpecies {
+	rs := calcSpeciesRandom(r)
+	mix := grass.mixes[int(mixidx)%grassmaxmixes]
+	for s := 0; s < len(grass.species)-1; s++ {
+		if rs < mix[s] {
+			return grass.species[s]
+		}
+	}
+	return grass.species[len(grass.species)-1]
+}
 
+// uploadMixes copies the cumulative weights of all species mixes to the GPU.
+func (grass *Grass) uploadMixes() {
+	data := make([]float32, 0, grassmaxmixes*grassmaxspecies)
+	for _, mix := range grass.mixes {
+		data = append(data, mix[:]...)
+	}
+	grass.mixbuffer.UploadArray(data)
+}
+
+// Render draws all grass blades using a LOD approach.
+// The instancecount is the number of visible Tiles and tilesperchunk is needed to look up the Tiles from the visible Chunk slots.
+// The field of the Trample map has to be bound to binding 6.
+// The blades are generated with the selected GrassPipeline.
+func (grass *Grass) Render(instancecount int32, tilesize float32, tilesperchunk int32, trample *Trample, M, V, P mgl32.Mat4, camerapos mgl32.Vec3) {
 	grass.grassAlpha.Bind(0)
-	grass.grassDiffuse0.Bind(1)
-	grass.grassDiffuse1.Bind(2)
-	grass.grassDiffuse2.Bind(3)
-	grass.grassDiffuse3.Bind(4)
+	grass.grassCard.Bind(1)
+	for i := range grass.grassDiffuse {
+		grass.grassDiffuse[i].Bind(uint32(i + 2))
+	}
+	grass.speciesbuffer.Bind(3)
+	grass.mixbuffer.Bind(4)
 
-	// render terrain
-	grass.shader.Use()
-	grass.shader.UpdateMat4("M", M)
-	grass.shader.UpdateMat4("V", V)
-	grass.shader.UpdateMat4("P", P)
-	grass.shader.UpdateFloat32("grassHeight", grass.height)
-	grass.shader.UpdateInt32("bladeCount", grass.bladecount)
-	grass.shader.UpdateFloat32("tilesize", tilesize)
-	grass.shader.UpdateVec3("cameraPos", camerapos)
-	grass.shader.UpdateVec3("lightDir", lightdir)
-	grass.shader.UpdateVec3("lightColor", lightcolor)
-	grass.shader.UpdateFloat32("ambientIntensity", 0.4)
-	grass.shader.UpdateFloat32("diffuseIntensity", 0.4)
-	grass.shader.UpdateFloat32("d1", grass.viewdist/8)
-	grass.shader.UpdateFloat32("d2", grass.viewdist)
-	grass.shader.UpdateFloat32("t", grass.time)
-	// wind related uniforms
-	grass.shader.UpdateInt32("radius", grass.windradius)
-	grass.shader.RenderInstanced(instancecount)
+	// upload the colliders closest to the camera
+	colliders := selectColliders(grass.colliders, camerapos)
+	grass.collidercount = int32(len(colliders) / collidersize)
+	if grass.collidercount > 0 {
+		grass.colliderbuffer.UploadArrayInRange(colliders, 0, int(grass.collidercount))
+	}
+	grass.colliderbuffer.Bind(5)
+
+	// render grass with the selected pipeline
+	switch grass.pipeline {
+	case COMPUTE:
+		grass.renderCompute(instancecount, tilesize, tilesperchunk, trample, M, V, P, camerapos)
+	default:
+		grass.renderGeometry(instancecount, tilesize, tilesperchunk, trample, M, V, P, camerapos)
+	}
 
 	grass.grassAlpha.Unbind()
-	grass.grassDiffuse0.Unbind()
-	grass.grassDiffuse1.Unbind()
-	grass.grassDiffuse2.Unbind()
-	grass.grassDiffuse3.Unbind()
+	grass.grassCard.Unbind()
+	for i := range grass.grassDiffuse {
+		grass.grassDiffuse[i].Unbind()
+	}
+	grass.speciesbuffer.Unbind()
+	grass.mixbuffer.Unbind()
+	grass.colliderbuffer.Unbind()
 
 	// update time
 	grass.time++
 }
+
+// renderGeometry draws one point per grass blade of each visible Tile and generates the blades in the geometry shader.
+func (grass *Grass) renderGeometry(instancecount int32, tilesize float32, tilesperchunk int32, trample *Trample, M, V, P mgl32.Mat4, camerapos mgl32.Vec3) {
+	grass.shader.Use()
+	grass.updateUniforms(&grass.shader, tilesize, tilesperchunk, trample, M, V, P, camerapos)
+	grass.shader.RenderInstanced(instancecount)
+}
+
+// updateUniforms sets the uniforms of the grass shaders, uniforms that a shader doesn't use are ignored.
+func (grass *Grass) updateUniforms(shader *engine.ShaderProgram, tilesize float32, tilesperchunk int32, trample *Trample, M, V, P mgl32.Mat4, camerapos mgl32.Vec3) {
+	lightdir := mgl32.Vec3{10.0, 0.0, 10.0}
+	lightcolor := mgl32.Vec3{1.0, 1.0, 0.0}
+
+	shader.UpdateMat4("M", M)
+	shader.UpdateMat4("V", V)
+	shader.UpdateMat4("P", P)
+	shader.UpdateFloat32("grassHeight", grass.height)
+	shader.UpdateInt32("bladeCount", grass.bladecount)
+	shader.UpdateInt32("speciesCount", int32(len(grass.species)))
+	shader.UpdateInt32("colliderCount", grass.collidercount)
+	shader.UpdateFloat32("tilesize", tilesize)
+	shader.UpdateInt32("tilesPerChunk", tilesperchunk)
+	shader.UpdateVec3("cameraPos", camerapos)
+	shader.UpdateVec3("lightDir", lightdir)
+	shader.UpdateVec3("lightColor", lightcolor)
+	shader.UpdateFloat32("ambientIntensity", 0.4)
+	shader.UpdateFloat32("diffuseIntensity", 0.4)
+	shader.UpdateFloat32("d1", grass.viewdist/8)
+	shader.UpdateFloat32("d2", grass.viewdist)
+	shader.UpdateFloat32("t", grass.time)
+	// wind related uniforms
+	shader.UpdateInt32("radius", grass.windradius)
+	// trample related uniforms
+	shader.UpdateInt32("trampleDim", trample.griddimension)
+	shader.UpdateInt32("trampleCenterX", trample.centerx)
+	shader.UpdateInt32("trampleCenterZ", trample.centerz)
+	shader.UpdateFloat32("trampleCellSize", trample.cellsize)
+}
+
+// Delete destroys the shaders, textures and buffers of the Grass including those of the COMPUTE GrassPipeline.
+func (grass *Grass) Delete() {
+	grass.shader.Delete()
+	grass.buffer.Delete()
+	grass.grassAlpha.Delete()
+	grass.grassCard.Delete()
+	for i := range grass.grassDiffuse {
+		grass.grassDiffuse[i].Delete()
+	}
+	grass.speciesbuffer.Delete()
+	grass.mixbuffer.Delete()
+	grass.colliderbuffer.Delete()
+	grass.compute.Delete()
+}
@@ -0,0 +1,220 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-gl/gl/v4.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/engine"
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// GrassPipeline is the way the grass blades are generated on the GPU.
+type GrassPipeline int
+
+const (
+	// GEOMETRY generates the blades of each Tile in a geometry shader.
+	GEOMETRY GrassPipeline = iota
+	// COMPUTE culls the blades in a compute shader and draws the remaining ones indirectly.
+	COMPUTE
+)
+
+const (
+	// grassbladesize is the number of floats of a single culled blade on the GPU, see Blade in grass/common.glsl.
+	grassbladesize = 16
+	// grassbladevertices is the number of vertices of the triangle strip of a single blade with the most segments.
+	grassbladevertices = 2 * (grassmaxsegments + 1)
+	// grasscullgroupsize is the number of blades handled by one work group of cull.comp.
+	grasscullgroupsize = 256
+	// grasscullmaxgroups is the maximum number of work groups of cull.comp, each invocation handles multiple blades if needed.
+	grasscullmaxgroups = 65535
+	// grasscullstorageblocks is the number of shader storage blocks of cull.comp, which is more than the minimum of 8 of OpenGL 4.3.
+	grasscullstorageblocks = 10
+	// grasscullbindings is the number of shader storage buffer bindings cull.comp uses, its highest binding is 10.
+	grasscullbindings = 11
+)
+
+// String returns the name of the GrassPipeline.
+func (pipeline GrassPipeline) String() string {
+	switch pipeline {
+	case GEOMETRY:
+		return "geometry"
+	case COMPUTE:
+		return "compute"
+	}
+	return "unknown"
+}
+
+// grassCompute holds the shaders and buffers of the COMPUTE GrassPipeline.
+// The cull shader writes all visible blades into the blade buffer and counts them in the command buffer,
+// then the blade shader draws one triangle strip per blade with the counts of the command buffer.
+// If the GPU supports less shader storage blocks in a compute shader or less shader storage buffer bindings
+// than the cull shader uses, the pipeline is not supported.
+// The blade buffer grows to the number of blades of all visible Tiles, but at most to maxblades,
+// which is the biggest shader storage block the GPU supports.
+type grassCompute struct {
+	supported      bool
+	storageblocks  int32
+	bindings       int32
+	maxblades      int32
+	limited        bool
+	cullshader     engine.ShaderProgram
+	bladeshader    engine.ShaderProgram
+	vao            engine.VAO
+	positionbuffer engine.SSBO
+	bladebuffer    engine.SSBO
+	commandbuffer  engine.SSBO
+	capacity       int32
+}
+
+// makeGrassCompute constructs the shaders and buffers of the COMPUTE GrassPipeline.
+// The positions are the random 2D root positions of the blades of one Tile.
+// An unsupported grassCompute without any shaders and buffers is returned if the GPU can't run the cull shader.
+func makeGrassCompute(shaderpath string, positions []float32) (grassCompute, error) {
+	// the cull shader can't be linked if there are not enough storage blocks or bindings
+	var storageblocks, bindings int32
+	gl.GetIntegerv(gl.MAX_COMPUTE_SHADER_STORAGE_BLOCKS, &storageblocks)
+	gl.GetIntegerv(gl.MAX_SHADER_STORAGE_BUFFER_BINDINGS, &bindings)
+	if storageblocks < grasscullstorageblocks || bindings < grasscullbindings {
+		return grassCompute{supported: false, storageblocks: storageblocks, bindings: bindings}, nil
+	}
+
+	// the blade buffer can't be bigger than the biggest storage block
+	var blocksize int64
+	gl.GetInteger64v(gl.MAX_SHADER_STORAGE_BLOCK_SIZE, &blocksize)
+
+	// make shaders
+	cullshader, err := engine.MakeComputeProgram(shaderpath + "/grass/cull.comp")
+	if err != nil {
+		return grassCompute{}, err
+	}
+	bladeshader, err := engine.MakeProgram(shaderpath+"/grass/blade.vert", shaderpath+"/grass/grass.frag")
+	if err != nil {
+		return grassCompute{}, err
+	}
+
+	// upload the root positions
+	positionbuffer := engine.MakeSSBO(4, len(positions))
+	positionbuffer.UploadArray(positions)
+
+	// the command consists of the vertex count, instance count, first vertex and base instance
+	bladebuffer := engine.MakeSSBO(grassbladesize*4, 1)
+	commandbuffer := engine.MakeSSBO(16, 1)
+
+	return grassCompute{
+		supported:      true,
+		storageblocks:  storageblocks,
+		bindings:       bindings,
+		maxblades:      calcMaxBlades(blocksize),
+		limited:        false,
+		cullshader:     cullshader,
+		bladeshader:    bladeshader,
+		vao:            engine.MakeVAO(gl.TRIANGLE_STRIP),
+		positionbuffer: positionbuffer,
+		bladebuffer:    bladebuffer,
+		commandbuffer:  commandbuffer,
+		capacity:       1,
+	}, nil
+}
+
+// checkSupport returns an error if the COMPUTE GrassPipeline can't be used on this GPU.
+func (compute *grassCompute) checkSupport() error {
+	if compute.supported {
+		return nil
+	}
+	if compute.storageblocks < grasscullstorageblocks {
+		return fmt.Errorf("The compute grass pipeline needs %v shader storage blocks but only %v are supported", grasscullstorageblocks, compute.storageblocks)
+	}
+	return fmt.Errorf("The compute grass pipeline needs %v shader storage buffer bindings but only %v are supported", grasscullbindings, compute.bindings)
+}
+
+// limitTiles returns how many of the tilecount visible Tiles with bladecount blades each fit into the blade buffer.
+// The visible Tiles are sorted by their distance to the camera, thus only the grass of the farthest Tiles is dropped.
+// A message is printed the first time Tiles are dropped.
+func (compute *grassCompute) limitTiles(tilecount, bladecount int32) int32 {
+	if int64(tilecount)*int64(bladecount) <= int64(compute.maxblades) {
+		return tilecount
+	}
+	if !compute.limited {
+		fmt.Printf("The blades of %v visible tiles exceed the maximum of %v blades, the grass of the farthest tiles is not drawn\n", tilecount, compute.maxblades)
+		compute.limited = true
+	}
+	return compute.maxblades / bladecount
+}
+
+// reserve makes sure that the blade buffer has room for count blades.
+func (compute *grassCompute) reserve(count int32) {
+	if count <= compute.capacity {
+		return
+	}
+	compute.bladebuffer.Resize(int(count))
+	compute.capacity = count
+}
+
+// Delete destroys the shaders and buffers of the COMPUTE GrassPipeline.
+// An unsupported grassCompute has nothing to destroy.
+func (compute *grassCompute) Delete() {
+	if !compute.supported {
+		return
+	}
+	compute.cullshader.Delete()
+	compute.bladeshader.Delete()
+	compute.vao.Delete()
+	compute.positionbuffer.Delete()
+	compute.bladebuffer.Delete()
+	compute.commandbuffer.Delete()
+}
+
+// renderCompute culls the blades of all visible Tiles in a compute shader and draws the remaining ones indirectly.
+// All buffers that are shared with the GEOMETRY GrassPipeline have to be bound already.
+func (grass *Grass) renderCompute(instancecount int32, tilesize float32, tilesperchunk int32, trample *Trample, M, V, P mgl32.Mat4, camerapos mgl32.Vec3) {
+	compute := &grass.compute
+	tilecount := compute.limitTiles(instancecount, grass.bladecount)
+	total := tilecount * grass.bladecount
+	if total <= 0 {
+		return
+	}
+	compute.reserve(total)
+
+	// reset the draw command, the cull shader counts the instances
+	compute.commandbuffer.UploadIntArrayInRange([]int32{grassbladevertices, 0, 0, 0}, 0, 1)
+	compute.positionbuffer.Bind(8)
+	compute.bladebuffer.Bind(9)
+	compute.commandbuffer.Bind(10)
+
+	// cull blades
+	groupcount := uint32(mathutils.CeilF32(float32(total) / grasscullgroupsize))
+	if groupcount > grasscullmaxgroups {
+		groupcount = grasscullmaxgroups
+	}
+	compute.cullshader.Use()
+	grass.updateUniforms(&compute.cullshader, tilesize, tilesperchunk, trample, M, V, P, camerapos)
+	compute.cullshader.UpdateInt32("tileCount", tilecount)
+	compute.cullshader.UpdateInt32("bladeCapacity", compute.capacity)
+	compute.cullshader.Compute(groupcount, 1, 1)
+
+	// make the culled blades and the command visible to the draw call
+	gl.MemoryBarrier(gl.SHADER_STORAGE_BARRIER_BIT | gl.COMMAND_BARRIER_BIT)
+
+	// draw culled blades
+	compute.bladeshader.Use()
+	grass.updateUniforms(&compute.bladeshader, tilesize, tilesperchunk, trample, M, V, P, camerapos)
+	compute.bladeshader.UpdateInt32("bladeCapacity", compute.capacity)
+	compute.vao.RenderIndirect(&compute.commandbuffer)
+
+	compute.positionbuffer.Unbind()
+	compute.bladebuffer.Unbind()
+	compute.commandbuffer.Unbind()
+}
+
+// calcMaxBlades returns the number of blades that fit into a shader storage block of blocksize bytes.
+func calcMaxBlades(blocksize int64) int32 {
+	count := blocksize / (grassbladesize * 4)
+	if count > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(count)
+}
@@ -0,0 +1,53 @@
+package scene
+
+import "testing"
+
+func TestSetPipelineUnsupported(t *testing.T) {
+	// the cull shader needs more storage blocks than the minimum of OpenGL 4.3
+	grass := Grass{compute: grassCompute{supported: false, storageblocks: 8, bindings: 8}}
+	if err := grass.SetPipeline(COMPUTE); err == nil {
+		t.Fatal("expected an error for an unsupported compute pipeline")
+	}
+
+	// the cull shader also needs more bindings than the minimum of OpenGL 4.3
+	grass.compute.storageblocks = grasscullstorageblocks
+	if err := grass.SetPipeline(COMPUTE); err == nil {
+		t.Fatal("expected an error for too few shader storage buffer bindings")
+	}
+	if grass.GetPipeline() != GEOMETRY {
+		t.Fatalf("expected the geometry pipeline to be kept but got %v", grass.GetPipeline())
+	}
+
+	grass.compute.supported = true
+	if err := grass.SetPipeline(COMPUTE); err != nil || grass.GetPipeline() != COMPUTE {
+		t.Fatalf("expected the compute pipeline but got %v: %v", grass.GetPipeline(), err)
+	}
+	if err := grass.SetPipeline(GEOMETRY); err != nil || grass.GetPipeline() != GEOMETRY {
+		t.Fatalf("expected the geometry pipeline but got %v: %v", grass.GetPipeline(), err)
+	}
+}
+
+func TestLimitTiles(t *testing.T) {
+	compute := grassCompute{supported: true, maxblades: calcMaxBlades(1000 * grassbladesize * 4)}
+	if compute.maxblades != 1000 {
+		t.Fatalf("expected 1000 blades but got %v", compute.maxblades)
+	}
+
+	// all tiles fit
+	if count := compute.limitTiles(10, 100); count != 10 || compute.limited {
+		t.Fatalf("expected all 10 tiles but got %v", count)
+	}
+
+	// only the nearest tiles fit
+	if count := compute.limitTiles(15, 100); count != 10 || !compute.limited {
+		t.Fatalf("expected 10 tiles but got %v", count)
+	}
+	if count := compute.limitTiles(15, 300); count != 3 {
+		t.Fatalf("expected 3 tiles but got %v", count)
+	}
+
+	// the number of blades doesn't overflow
+	if count := compute.limitTiles(1<<20, 1<<16); count != 0 {
+		t.Fatalf("expected no tiles but got %v", count)
+	}
+}
@@ -0,0 +1,412 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+const (
+	// grasscutmaxtiles is the maximum number of Tiles around a CutArea that are checked by a single cut.
+	grasscutmaxtiles = 1 << 22
+)
+
+// CutArea is a region in the x-z plane in which the grass is cut, either a circle or a polygon.
+// A Tile is part of the CutArea if its center lies within the region.
+type CutArea struct {
+	center mgl32.Vec2
+	radius float32
+	points []mgl32.Vec2
+}
+
+// MakeCircleArea creates a circular CutArea around center with the specified radius.
+// The height of center is ignored.
+func MakeCircleArea(center mgl32.Vec3, radius float32) CutArea {
+	return CutArea{
+		center: mgl32.Vec2{center.X(), center.Z()},
+		radius: radius,
+		points: nil,
+	}
+}
+
+// MakePolygonArea creates a CutArea from the outline of a polygon given by at least 3 points in order.
+// The heights of the points are ignored and the polygon may be concave.
+func MakePolygonArea(points []mgl32.Vec3) CutArea {
+	outline := make([]mgl32.Vec2, len(points))
+	for i, p := range points {
+		outline[i] = mgl32.Vec2{p.X(), p.Z()}
+	}
+	return CutArea{
+		center: mgl32.Vec2{},
+		radius: 0,
+		points: outline,
+	}
+}
+
+// isPolygon returns true if the CutArea is a polygon and not a circle.
+func (area *CutArea) isPolygon() bool {
+	return area.points != nil
+}
+
+// contains returns true if the position (x,z) lies within the CutArea.
+// Points within a polygon are found by counting the crossings of a ray along the x-axis with the outline.
+func (area *CutArea) contains(x, z float32) bool {
+	if !area.isPolygon() {
+		dx := x - area.center.X()
+		dz := z - area.center.Y()
+		return dx*dx+dz*dz <= area.radius*area.radius
+	}
+
+	inside := false
+	n := len(area.points)
+	for i, j := 0, n-1; i < n; j, i = i, i+1 {
+		a, b := area.points[i], area.points[j]
+		if (a.Y() > z) != (b.Y() > z) && x < (b.X()-a.X())*(z-a.Y())/(b.Y()-a.Y())+a.X() {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// getBounds returns the corners (minx,minz) and (maxx,maxz) of the axis aligned rectangle around the CutArea.
+func (area *CutArea) getBounds() (float32, float32, float32, float32) {
+	if !area.isPolygon() {
+		return area.center.X() - area.radius, area.center.Y() - area.radius,
+			area.center.X() + area.radius, area.center.Y() + area.radius
+	}
+
+	minx, minz := area.points[0].X(), area.points[0].Y()
+	maxx, maxz := minx, minz
+	for _, p := range area.points[1:] {
+		minx = mathutils.MinF32(minx, p.X())
+		minz = mathutils.MinF32(minz, p.Y())
+		maxx = mathutils.MaxF32(maxx, p.X())
+		maxz = mathutils.MaxF32(maxz, p.Y())
+	}
+	return minx, minz, maxx, maxz
+}
+
+// isValid returns true if the CutArea describes a region that can be cut.
+func (area *CutArea) isValid() bool {
+	if !area.isPolygon() {
+		return isFinite(area.center.X()) && isFinite(area.center.Y()) && isFinite(area.radius) && area.radius > 0
+	}
+	if len(area.points) < 3 {
+		return false
+	}
+	for _, p := range area.points {
+		if !isFinite(p.X()) || !isFinite(p.Y()) {
+			return false
+		}
+	}
+	return true
+}
+
+// tileRect is a rectangle of Tiles of level 0 from (minx,minz) inclusive to (maxx,maxz) exclusive.
+type tileRect struct {
+	minx int32
+	minz int32
+	maxx int32
+	maxz int32
+}
+
+// overlaps returns true if both rectangles share at least one Tile.
+func (rect tileRect) overlaps(other tileRect) bool {
+	return rect.minx < other.maxx && other.minx < rect.maxx && rect.minz < other.maxz && other.minz < rect.maxz
+}
+
+// grassCut is the height multiplier of the grass on the Tile of level 0 at (X,Z).
+type grassCut struct {
+	X      int32   `json:"x"`
+	Z      int32   `json:"z"`
+	Height float32 `json:"height"`
+}
+
+// cutKey is the coordinate of a Tile of level 0 in the map of the GrassCuts.
+type cutKey struct {
+	x, z int32
+}
+
+// grassCutFile is the layout of the GrassCuts as stored in a file.
+type grassCutFile struct {
+	TileSize float32    `json:"tilesize"`
+	Tiles    []grassCut `json:"tiles"`
+}
+
+// GrassCuts stores the height multiplier of the grass of each Tile of level 0 that has been cut.
+// A multiplier of 1 is grass at its full height and 0 is grass that has been mowed down to the ground.
+// The Tiles are addressed in world space and are not repeated with the terrain, thus mowing a lawn only mows it once.
+// Since the multipliers are kept independent of the Chunks they survive unloading and reloading of Chunks.
+// Reading is guarded by a lock since the grass can be cut while Chunks are built in the background.
+type GrassCuts struct {
+	tilesize float32
+	cuts     map[cutKey]grassCut
+	lock     *sync.RWMutex
+}
+
+// MakeGrassCuts creates GrassCuts without any cut grass for Tiles of the given size.
+func MakeGrassCuts(tilesize float32) GrassCuts {
+	return GrassCuts{
+		tilesize: tilesize,
+		cuts:     map[cutKey]grassCut{},
+		lock:     &sync.RWMutex{},
+	}
+}
+
+// Cut lowers the height multiplier of all Tiles within the CutArea to height between 0 and 1.
+// Grass that is already shorter is not changed, thus grass only grows back by Clear or Load.
+// Returns false if no Tile has been changed.
+func (cuts *GrassCuts) Cut(area CutArea, height float32) bool {
+	if !area.isValid() || !isFinite(height) {
+		return false
+	}
+	height = mgl32.Clamp(height, 0, 1)
+
+	// tiles whose centers could be inside of the area
+	rect := calcAreaTileRect(area, cuts.tilesize)
+	if int64(rect.maxx-rect.minx)*int64(rect.maxz-rect.minz) > grasscutmaxtiles {
+		return false
+	}
+
+	cuts.lock.Lock()
+	defer cuts.lock.Unlock()
+
+	changed := false
+	ts := cuts.tilesize
+	for tz := rect.minz; tz < rect.maxz; tz++ {
+		for tx := rect.minx; tx < rect.maxx; tx++ {
+			if !area.contains((float32(tx)+0.5)*ts, (float32(tz)+0.5)*ts) {
+				continue
+			}
+			if height >= cuts.getTileHeight(tx, tz) {
+				continue
+			}
+			cuts.cuts[cutKey{tx, tz}] = grassCut{tx, tz, height}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Clear lets all cut grass grow back to its full height.
+func (cuts *GrassCuts) Clear() {
+	cuts.lock.Lock()
+	defer cuts.lock.Unlock()
+	cuts.cuts = map[cutKey]grassCut{}
+}
+
+// GetHeight returns the height multiplier of the grass of the Tile of level 0 containing the position (x,z).
+func (cuts *GrassCuts) GetHeight(x, z float32) float32 {
+	cuts.lock.RLock()
+	defer cuts.lock.RUnlock()
+	tx := int32(mathutils.FloorF32(x / cuts.tilesize))
+	tz := int32(mathutils.FloorF32(z / cuts.tilesize))
+	return cuts.getTileHeight(tx, tz)
+}
+
+// Save writes the height multipliers of all cut Tiles as JSON to the specified path.
+// The Tiles are sorted such that the same cuts always yield the same file.
+func (cuts *GrassCuts) Save(path string) error {
+	cuts.lock.RLock()
+	file := grassCutFile{
+		TileSize: cuts.tilesize,
+		Tiles:    make([]grassCut, 0, len(cuts.cuts)),
+	}
+	for _, cut := range cuts.cuts {
+		file.Tiles = append(file.Tiles, cut)
+	}
+	cuts.lock.RUnlock()
+
+	sort.Slice(file.Tiles, func(i, j int) bool {
+		if file.Tiles[i].Z != file.Tiles[j].Z {
+			return file.Tiles[i].Z < file.Tiles[j].Z
+		}
+		return file.Tiles[i].X < file.Tiles[j].X
+	})
+
+	content, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// Load replaces all height multipliers by the ones saved at the specified path.
+// The file has to be saved from GrassCuts with the same Tile size.
+func (cuts *GrassCuts) Load(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file grassCutFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return fmt.Errorf("Invalid grass cut file %v: %v", path, err)
+	}
+	if file.TileSize != cuts.tilesize {
+		return fmt.Errorf("Grass cut file %v has a tile size of %v but the terrain has a tile size of %v", path, file.TileSize, cuts.tilesize)
+	}
+
+	loaded := make(map[cutKey]grassCut, len(file.Tiles))
+	for _, cut := range file.Tiles {
+		if !isFinite(cut.Height) {
+			return fmt.Errorf("Invalid grass height %v of tile (%v,%v) in %v", cut.Height, cut.X, cut.Z, path)
+		}
+		cut.Height = mgl32.Clamp(cut.Height, 0, 1)
+		if cut.Height < 1 {
+			loaded[cutKey{cut.X, cut.Z}] = cut
+		}
+	}
+
+	cuts.lock.Lock()
+	defer cuts.lock.Unlock()
+	cuts.cuts = loaded
+	return nil
+}
+
+// calcChunkHeights returns the height multiplier of each Tile of the Chunk of level lod at (cx,cz).
+// A Tile of a coarser level gets the average multiplier of all Tiles of level 0 it covers.
+// Depending on which is less work either all covered Tiles are looked up or all cut Tiles are visited.
+func (cuts *GrassCuts) calcChunkHeights(lod, cx, cz, chunkresolution int32) []float32 {
+	heights := make([]float32, chunkresolution*chunkresolution)
+	for i := range heights {
+		heights[i] = 1.0
+	}
+
+	cuts.lock.RLock()
+	defer cuts.lock.RUnlock()
+	if len(cuts.cuts) == 0 {
+		return heights
+	}
+
+	// sum up the heights and number of the cut tiles within each coarse tile
+	step := int32(1) << uint(lod)
+	rect := calcChunkTileRect(lod, cx, cz, chunkresolution)
+	sums := make([]float32, len(heights))
+	counts := make([]int32, len(heights))
+	add := func(cut grassCut) {
+		idx := ((cut.Z-rect.minz)/step)*chunkresolution + (cut.X-rect.minx)/step
+		sums[idx] += cut.Height
+		counts[idx]++
+	}
+	if int64(len(cuts.cuts)) < int64(rect.maxx-rect.minx)*int64(rect.maxz-rect.minz) {
+		for _, cut := range cuts.cuts {
+			if cut.X >= rect.minx && cut.X < rect.maxx && cut.Z >= rect.minz && cut.Z < rect.maxz {
+				add(cut)
+			}
+		}
+	} else {
+		for tz := rect.minz; tz < rect.maxz; tz++ {
+			for tx := rect.minx; tx < rect.maxx; tx++ {
+				if cut, ok := cuts.cuts[cutKey{tx, tz}]; ok {
+					add(cut)
+				}
+			}
+		}
+	}
+
+	// the remaining tiles have not been cut
+	for i := range heights {
+		if counts[i] > 0 {
+			heights[i] = (sums[i] + float32(step*step-counts[i])) / float32(step*step)
+		}
+	}
+	return heights
+}
+
+// getTileHeight returns the height multiplier of the Tile of level 0 at (tx,tz).
+// The lock has to be held by the caller.
+func (cuts *GrassCuts) getTileHeight(tx, tz int32) float32 {
+	if cut, ok := cuts.cuts[cutKey{tx, tz}]; ok {
+		return cut.Height
+	}
+	return 1.0
+}
+
+// calcAreaTileRect returns the rectangle of Tiles of level 0 whose centers could be within the CutArea.
+func calcAreaTileRect(area CutArea, tilesize float32) tileRect {
+	minx, minz, maxx, maxz := area.getBounds()
+	return tileRect{
+		minx: int32(mathutils.FloorF32(minx/tilesize - 0.5)),
+		minz: int32(mathutils.FloorF32(minz/tilesize - 0.5)),
+		maxx: int32(mathutils.CeilF32(maxx/tilesize-0.5)) + 1,
+		maxz: int32(mathutils.CeilF32(maxz/tilesize-0.5)) + 1,
+	}
+}
+
+// calcChunkTileRect returns the rectangle of Tiles of level 0 covered by the Chunk of level lod at (cx,cz).
+func calcChunkTileRect(lod, cx, cz, chunkresolution int32) tileRect {
+	size := chunkresolution * (int32(1) << uint(lod))
+	return tileRect{
+		minx: cx * size,
+		minz: cz * size,
+		maxx: (cx + 1) * size,
+		maxz: (cz + 1) * size,
+	}
+}
+
+// CutGrass lowers the grass within the CutArea to height times its full height, where height is between 0 and 1.
+// The grass heights of the loaded Chunks are updated right away.
+func (terrain *Terrain) CutGrass(area CutArea, height float32) {
+	if terrain.grass.Cut(area, height) {
+		terrain.recut(calcAreaTileRect(area, terrain.tilesize))
+	}
+}
+
+// ClearGrassCuts lets all cut grass grow back to its full height.
+func (terrain *Terrain) ClearGrassCuts() {
+	terrain.grass.cuts.Clear()
+	terrain.recutAll()
+}
+
+// SaveGrassCuts writes the height multipliers of all cut Tiles to the specified path.
+func (terrain *Terrain) SaveGrassCuts(path string) error {
+	return terrain.grass.cuts.Save(path)
+}
+
+// LoadGrassCuts replaces the height multipliers of all Tiles by the ones saved at the specified path.
+func (terrain *Terrain) LoadGrassCuts(path string) error {
+	if err := terrain.grass.cuts.Load(path); err != nil {
+		return err
+	}
+	terrain.recutAll()
+	return nil
+}
+
+// recut updates the grass heights of all loaded Chunks that cover Tiles within rect.
+// Cached Chunks and Chunks that are currently built in the background are discarded if they cover Tiles within rect as well,
+// thus mowing every frame doesn't hold back the loading of other Chunks.
+func (terrain *Terrain) recut(rect tileRect) {
+	touches := func(chunk *Chunk) bool {
+		return calcChunkTileRect(chunk.lod, chunk.x, chunk.z, terrain.cf.chunkresolution).overlaps(rect)
+	}
+
+	for key, chunk := range terrain.chunks {
+		if !touches(&chunk) {
+			continue
+		}
+		chunk.heights = terrain.cf.makeHeights(chunk.lod, chunk.x, chunk.z)
+		terrain.slots.Upload(chunk.slot, &chunk)
+		terrain.chunks[key] = chunk
+	}
+	terrain.cache.InvalidateFunc(touches)
+	terrain.loader.DiscardFunc(touches)
+}
+
+// recutAll updates the grass heights of all loaded Chunks.
+// Cached Chunks and Chunks that are currently built in the background are discarded.
+func (terrain *Terrain) recutAll() {
+	for key, chunk := range terrain.chunks {
+		chunk.heights = terrain.cf.makeHeights(chunk.lod, chunk.x, chunk.z)
+		terrain.slots.Upload(chunk.slot, &chunk)
+		terrain.chunks[key] = chunk
+	}
+	terrain.cache.Clear()
+	terrain.loader.Discard()
+}
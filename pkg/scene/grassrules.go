@@ -0,0 +1,159 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// GrassRule decides how much grass grows at a position of the terrain.
+// The position (x,z) is in world space, height is the height of the terrain and slope is the angle between the terrain normal and the y-axis in radians.
+// The returned density factor ranges from 0 for no grass to 1 for the full amount of grass.
+type GrassRule interface {
+	GetDensity(x, z, height, slope float32) float32
+}
+
+// GrassRules combines multiple GrassRules by multiplying their density factors.
+// Thus a Tile only gets grass if all rules allow it.
+type GrassRules struct {
+	rules []GrassRule
+}
+
+// MakeGrassRules constructs GrassRules from the specified rules.
+// Without any rule every Tile gets the full amount of grass.
+func MakeGrassRules(rules ...GrassRule) GrassRules {
+	return GrassRules{
+		rules: rules,
+	}
+}
+
+// EvaluateTile returns the density factor of the Tile by evaluating all rules at the center of the Tile.
+// The height and slope are derived from the plane data of the Tile.
+// The steeper of both triangles decides the slope of the Tile.
+func (rules *GrassRules) EvaluateTile(tile Tile) float32 {
+	if len(rules.rules) == 0 || len(tile.data) < 10 {
+		return 1.0
+	}
+
+	// extract plane and position data
+	d := tile.data
+	x, z := d[8], d[9]
+	ny1 := mathutils.AbsF32(d[1])
+	ny2 := mathutils.AbsF32(d[5])
+
+	// the center lies on the shared edge of both triangles thus both planes give the same height
+	var height float32
+	if ny1 >= ny2 && ny1 > 0 {
+		height = -(d[3] + d[0]*x + d[2]*z) / d[1]
+	} else if ny2 > 0 {
+		height = -(d[7] + d[4]*x + d[6]*z) / d[5]
+	}
+	slope := float32(math.Acos(float64(mathutils.MinF32(1, mathutils.MinF32(ny1, ny2)))))
+
+	return rules.Evaluate(x, z, height, slope)
+}
+
+// Evaluate returns the product of the density factors of all rules at the position (x,z) with the specified height and slope.
+func (rules *GrassRules) Evaluate(x, z, height, slope float32) float32 {
+	var density float32 = 1.0
+	for _, rule := range rules.rules {
+		density *= mgl32.Clamp(rule.GetDensity(x, z, height, slope), 0, 1)
+		if density == 0 {
+			break
+		}
+	}
+	return density
+}
+
+// SlopeRule removes grass from slopes steeper than maxslope.
+// Between maxslope and maxslope+fade the grass thins out linearly.
+// Both angles are specified in radians.
+type SlopeRule struct {
+	maxslope float32
+	fade     float32
+}
+
+// MakeSlopeRule constructs a SlopeRule with the maximum slope and the fade band in radians.
+func MakeSlopeRule(maxslope, fade float32) SlopeRule {
+	return SlopeRule{
+		maxslope: maxslope,
+		fade:     fade,
+	}
+}
+
+// GetDensity returns 1 for slopes up to the maximum slope and 0 for slopes beyond the fade band.
+func (rule SlopeRule) GetDensity(x, z, height, slope float32) float32 {
+	return 1.0 - calcRamp(slope, rule.maxslope, rule.maxslope+rule.fade)
+}
+
+// AltitudeRule only grows grass between the minimum and the maximum altitude.
+// Below minaltitude and above maxaltitude the grass thins out linearly within the fade band.
+type AltitudeRule struct {
+	minaltitude float32
+	maxaltitude float32
+	fade        float32
+}
+
+// MakeAltitudeRule constructs an AltitudeRule with the altitude range and the fade band in world units.
+func MakeAltitudeRule(minaltitude, maxaltitude, fade float32) AltitudeRule {
+	return AltitudeRule{
+		minaltitude: minaltitude,
+		maxaltitude: maxaltitude,
+		fade:        fade,
+	}
+}
+
+// GetDensity returns 1 for heights within the altitude range and 0 for heights beyond the fade band.
+func (rule AltitudeRule) GetDensity(x, z, height, slope float32) float32 {
+	lower := 1.0 - calcRamp(rule.minaltitude-height, 0, rule.fade)
+	upper := 1.0 - calcRamp(height-rule.maxaltitude, 0, rule.fade)
+	return lower * upper
+}
+
+// NoiseRule masks the grass with a simplex noise to break up uniform meadows into patches.
+// The noise is mapped onto the range from 0 to 1 where grass grows above the threshold.
+// Between threshold and threshold+fade the grass thins out linearly.
+// The featuresize is the approximate size of a patch in world units.
+type NoiseRule struct {
+	noise       mathutils.Noise
+	featuresize float32
+	threshold   float32
+	fade        float32
+}
+
+// MakeNoiseRule constructs a NoiseRule from a seed, the size of the patches in world units, the threshold and the fade band.
+// The same seed always yields the same mask.
+func MakeNoiseRule(seed int64, featuresize, threshold, fade float32) NoiseRule {
+	if featuresize <= 0 {
+		featuresize = 1
+	}
+
+	return NoiseRule{
+		noise:       mathutils.MakeNoise(seed),
+		featuresize: featuresize,
+		threshold:   threshold,
+		fade:        fade,
+	}
+}
+
+// GetDensity returns the noise mask at the position (x,z).
+func (rule NoiseRule) GetDensity(x, z, height, slope float32) float32 {
+	n := rule.noise.Simplex(float64(x/rule.featuresize), float64(z/rule.featuresize))
+	n = (n + 1) / 2
+	return calcRamp(float32(n), rule.threshold, rule.threshold+rule.fade)
+}
+
+// calcRamp returns 0 for values up to start, 1 for values from end on and interpolates linearly in between.
+// If start and end are the same this is a step function.
+func calcRamp(val, start, end float32) float32 {
+	if val <= start {
+		return 0
+	}
+	if val >= end {
+		return 1
+	}
+	return (val - start) / (end - start)
+}
@@ -0,0 +1,125 @@
+package scene
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// constantRule is a GrassRule that returns the same density everywhere.
+type constantRule float32
+
+func (rule constantRule) GetDensity(x, z, height, slope float32) float32 {
+	return float32(rule)
+}
+
+// expectDensity fails the test if the density is not close to the expected one.
+func expectDensity(t *testing.T, name string, density, expected float32) {
+	t.Helper()
+	if mgl32.Abs(density-expected) > 1e-4 {
+		t.Fatalf("%v: expected density %v but got %v", name, expected, density)
+	}
+}
+
+func TestSlopeRule(t *testing.T) {
+	rule := MakeSlopeRule(mgl32.DegToRad(40), mgl32.DegToRad(10))
+
+	expectDensity(t, "flat", rule.GetDensity(0, 0, 0, 0), 1)
+	expectDensity(t, "maximum slope", rule.GetDensity(0, 0, 0, mgl32.DegToRad(40)), 1)
+	expectDensity(t, "half of the fade band", rule.GetDensity(0, 0, 0, mgl32.DegToRad(45)), 0.5)
+	expectDensity(t, "end of the fade band", rule.GetDensity(0, 0, 0, mgl32.DegToRad(50)), 0)
+	expectDensity(t, "cliff", rule.GetDensity(0, 0, 0, mgl32.DegToRad(80)), 0)
+}
+
+func TestAltitudeRule(t *testing.T) {
+	rule := MakeAltitudeRule(10, 100, 20)
+
+	expectDensity(t, "within the range", rule.GetDensity(0, 0, 50, 0), 1)
+	expectDensity(t, "minimum altitude", rule.GetDensity(0, 0, 10, 0), 1)
+	expectDensity(t, "below the minimum", rule.GetDensity(0, 0, 0, 0), 0.5)
+	expectDensity(t, "far below the minimum", rule.GetDensity(0, 0, -20, 0), 0)
+	expectDensity(t, "above the maximum", rule.GetDensity(0, 0, 115, 0), 0.25)
+	expectDensity(t, "far above the maximum", rule.GetDensity(0, 0, 200, 0), 0)
+}
+
+func TestGrassRulesEvaluate(t *testing.T) {
+	none := MakeGrassRules()
+	expectDensity(t, "no rules", none.Evaluate(0, 0, 0, 0), 1)
+
+	// densities are multiplied and clamped between 0 and 1
+	rules := MakeGrassRules(constantRule(0.5), constantRule(0.8), constantRule(3))
+	expectDensity(t, "product", rules.Evaluate(0, 0, 0, 0), 0.4)
+	rules = MakeGrassRules(constantRule(0.5), constantRule(-1))
+	expectDensity(t, "negative density", rules.Evaluate(0, 0, 0, 0), 0)
+
+	// rules combine with each other
+	rules = MakeGrassRules(MakeSlopeRule(mgl32.DegToRad(40), mgl32.DegToRad(10)), MakeAltitudeRule(10, 100, 20))
+	expectDensity(t, "flat meadow", rules.Evaluate(0, 0, 50, 0), 1)
+	expectDensity(t, "steep meadow", rules.Evaluate(0, 0, 50, mgl32.DegToRad(45)), 0.5)
+	expectDensity(t, "steep and low", rules.Evaluate(0, 0, 0, mgl32.DegToRad(45)), 0.25)
+}
+
+func TestGrassRulesEvaluateTile(t *testing.T) {
+	rules := MakeGrassRules(MakeSlopeRule(mgl32.DegToRad(40), mgl32.DegToRad(10)), MakeAltitudeRule(10, 100, 20))
+
+	// a block spans 128 units, thus the planes rise by dx/128 per unit
+	flat := TileFactory{tilesize: 2, tilesperblock: 65, source: &planeSource{50, 0, 0}}
+	steep := TileFactory{tilesize: 2, tilesperblock: 65, source: &planeSource{50, 256, 0}}
+	low := TileFactory{tilesize: 2, tilesperblock: 65, source: &planeSource{0, 0, 0}}
+
+	expectDensity(t, "flat tile", rules.EvaluateTile(flat.MakeTile(3, 4)), 1)
+	expectDensity(t, "steep tile", rules.EvaluateTile(steep.MakeTile(3, 4)), 0)
+	expectDensity(t, "low tile", rules.EvaluateTile(low.MakeTile(3, 4)), 0.5)
+}
+
+func TestTileDensity(t *testing.T) {
+	// splat map of dirt with half of the grass density using the last species mix
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, color.RGBA{85, 128, 255, 255})
+		}
+	}
+	path := filepath.Join(t.TempDir(), "splat.png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	splatmap, err := MakeSplatmap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the density of the splat map is thinned out by the rules
+	rules := MakeGrassRules(MakeAltitudeRule(10, 100, 20))
+	tf := TileFactory{tilesize: 2, tilesperblock: 65, sealevel: 5, source: &planeSource{0, 0, 0}, splatmap: &splatmap, rules: &rules}
+	for _, test := range []struct {
+		base      float32
+		density   float32
+		submerged bool
+	}{
+		{50, 128.0 / 255.0, false},
+		{0, 0, true},
+		{8, 0.9 * 128.0 / 255.0, false},
+	} {
+		tf.source = &planeSource{test.base, 0, 0}
+		packed := tf.MakeTile(1, 2).data[11]
+		material, density, submerged := unpackMaterial(packed)
+		if material != DIRT || submerged != test.submerged {
+			t.Fatalf("height %v: expected material %v submerged %v but got %v %v", test.base, DIRT, test.submerged, material, submerged)
+		}
+		if mix := unpackMix(packed); mix != grassmaxmixes-1 {
+			t.Fatalf("height %v: expected species mix %v but got %v", test.base, grassmaxmixes-1, mix)
+		}
+		expectDensity(t, "tile density", density, test.density)
+	}
+}
@@ -0,0 +1,205 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	// grassmaxspecies is the maximum number of GrassSpecies of the Grass.
+	grassmaxspecies = 8
+	// grassmaxmixes is the maximum number of species mixes the Tiles can choose from, see Splatmap.
+	grassmaxmixes = 8
+	// grassmaxsegments is the maximum number of segments of a grass blade, see max_vertices in grass.geom.
+	grassmaxsegments = 8
+	// grassmaxtextures is the maximum number of different textures of all GrassSpecies, see grass.frag.
+	grassmaxtextures = 7
+	// grassspeciestextures is the maximum number of textures of a single GrassSpecies.
+	grassspeciestextures = 4
+	// grassspeciessize is the number of floats of a single GrassSpecies on the GPU.
+	grassspeciessize = 16
+)
+
+// GrassSpecies describes the look of one kind of grass.
+// The height and width of each blade are chosen randomly between the minimum and maximum values.
+// The Segments are the number of segments of a blade at the highest level of detail.
+// Stiffness ranges from 0 for grass that fully bends in the wind to 1 for grass that doesn't move at all.
+// The Tint is multiplied with the color of the texture.
+// Textures are the file names of up to 4 diffuse textures relative to the texture path, each blade uses one of them.
+type GrassSpecies struct {
+	MinHeight float32
+	MaxHeight float32
+	MinWidth  float32
+	MaxWidth  float32
+	Segments  int
+	Stiffness float32
+	Tint      mgl32.Vec3
+	Textures  []string
+}
+
+// MakeDefaultGrassSpecies returns the meadow grass that grows when no other GrassSpecies are specified.
+func MakeDefaultGrassSpecies() GrassSpecies {
+	return GrassSpecies{
+		MinHeight: 45.0,
+		MaxHeight: 50.0,
+		MinWidth:  0.6,
+		MaxWidth:  0.9,
+		Segments:  5,
+		Stiffness: 0.0,
+		Tint:      mgl32.Vec3{1.0, 1.0, 1.0},
+		Textures:  []string{"grass1.jpg", "grass2.jpg", "grass3.jpg"},
+	}
+}
+
+// MakeLawnSpecies returns short and dense lawn grass.
+func MakeLawnSpecies() GrassSpecies {
+	return GrassSpecies{
+		MinHeight: 15.0,
+		MaxHeight: 20.0,
+		MinWidth:  0.5,
+		MaxWidth:  0.7,
+		Segments:  3,
+		Stiffness: 0.3,
+		Tint:      mgl32.Vec3{0.9, 1.0, 0.9},
+		Textures:  []string{"grass2.jpg", "grass3.jpg"},
+	}
+}
+
+// MakeReedSpecies returns tall, thin and stiff reeds.
+func MakeReedSpecies() GrassSpecies {
+	return GrassSpecies{
+		MinHeight: 80.0,
+		MaxHeight: 110.0,
+		MinWidth:  0.3,
+		MaxWidth:  0.5,
+		Segments:  7,
+		Stiffness: 0.6,
+		Tint:      mgl32.Vec3{0.8, 0.9, 0.7},
+		Textures:  []string{"grass1.jpg"},
+	}
+}
+
+// MakeWheatSpecies returns golden wheat.
+func MakeWheatSpecies() GrassSpecies {
+	return GrassSpecies{
+		MinHeight: 60.0,
+		MaxHeight: 70.0,
+		MinWidth:  0.5,
+		MaxWidth:  0.7,
+		Segments:  6,
+		Stiffness: 0.4,
+		Tint:      mgl32.Vec3{1.3, 1.1, 0.5},
+		Textures:  []string{"grass1.jpg", "grass2.jpg"},
+	}
+}
+
+// getMaxSpeciesHeight returns the maximum blade height of all GrassSpecies.
+func getMaxSpeciesHeight(species []GrassSpecies) float32 {
+	var height float32
+	for _, s := range species {
+		if s.MaxHeight > height {
+			height = s.MaxHeight
+		}
+	}
+	return height
+}
+
+// collectSpeciesTextures returns the unique texture file names of all GrassSpecies and
+// for each GrassSpecies the texture IDs of its textures.
+// The texture IDs start at 1 since the ID 0 is the grass card of the far away Tiles.
+func collectSpeciesTextures(species []GrassSpecies) ([]string, [][]int, error) {
+	var textures []string
+	ids := make([][]int, len(species))
+	for i, s := range species {
+		if len(s.Textures) == 0 || len(s.Textures) > grassspeciestextures {
+			return nil, nil, fmt.Errorf("Grass species %v needs between 1 and %v textures", i, grassspeciestextures)
+		}
+		for _, texture := range s.Textures {
+			id := -1
+			for t, other := range textures {
+				if other == texture {
+					id = t + 1
+					break
+				}
+			}
+			if id < 0 {
+				textures = append(textures, texture)
+				id = len(textures)
+			}
+			ids[i] = append(ids[i], id)
+		}
+	}
+	if len(textures) > grassmaxtextures {
+		return nil, nil, fmt.Errorf("Grass species use %v different textures but at most %v are supported", len(textures), grassmaxtextures)
+	}
+	return textures, ids, nil
+}
+
+// makeSpeciesData packs all GrassSpecies into 4 vec4 each, see Species in grass/common.glsl.
+// The first vec4 holds the height and width ranges, the second the tint and stiffness,
+// the third the texture IDs and the fourth the segment count and texture count.
+func makeSpeciesData(species []GrassSpecies, textureids [][]int) []float32 {
+	data := make([]float32, 0, grassspeciessize*len(species))
+	for i, s := range species {
+		var textures [grassspeciestextures]float32
+		for t, id := range textureids[i] {
+			textures[t] = float32(id)
+		}
+		data = append(data,
+			s.MinHeight, s.MaxHeight, s.MinWidth, s.MaxWidth,
+			s.Tint.X(), s.Tint.Y(), s.Tint.Z(), mgl32.Clamp(s.Stiffness, 0, 1),
+			textures[0], textures[1], textures[2], textures[3],
+			float32(clampSegments(s.Segments)), float32(len(textureids[i])), 0, 0,
+		)
+	}
+	return data
+}
+
+// clampSegments limits the segment count of a grass blade between 1 and the maximum number of segments.
+func clampSegments(segments int) int {
+	if segments < 1 {
+		return 1
+	}
+	if segments > grassmaxsegments {
+		return grassmaxsegments
+	}
+	return segments
+}
+
+// calcSpeciesRandom derives the random number that selects the GrassSpecies of a blade from its random number r,
+// see getSpecies in grass/common.glsl.
+func calcSpeciesRandom(r float32) float32 {
+	return float32(fract(float64(r+1) * 17.0))
+}
+
+// makeSpeciesMix normalizes the weights of the GrassSpecies and returns their cumulative sums.
+// Without any positive weight all GrassSpecies are mixed equally.
+func makeSpeciesMix(weights []float32, speciescount int) [grassmaxspecies]float32 {
+	var sum float32
+	for s := 0; s < speciescount && s < len(weights); s++ {
+		sum += float32(math.Max(0, float64(weights[s])))
+	}
+
+	var mix [grassmaxspecies]float32
+	var cumulative float32
+	for s := 0; s < grassmaxspecies; s++ {
+		if s < speciescount {
+			if sum > 0 {
+				if s < len(weights) && weights[s] > 0 {
+					cumulative += weights[s] / sum
+				}
+			} else {
+				cumulative += 1.0 / float32(speciescount)
+			}
+		}
+		mix[s] = cumulative
+	}
+	// the last species catches the rounding errors
+	if speciescount > 0 {
+		mix[speciescount-1] = 1
+	}
+	return mix
+}
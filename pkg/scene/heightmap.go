@@ -1,19 +1,43 @@
 // Package scene contains all main entities for rendering and/or interaction with the user.
 package scene
 
-import "github.com/adrianderstroff/realtime-grass/pkg/engine"
+import (
+	"math"
+	"sync"
 
-// Heightmap holds the normalized image data of a height texture as well as the maximum height.
-// Values read range from 0 to maximum height.
+	"github.com/adrianderstroff/realtime-grass/pkg/engine"
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// HeightmapFilter specifies how a Heightmap is sampled between pixels.
+type HeightmapFilter int
+
+const (
+	NEAREST HeightmapFilter = iota
+	BILINEAR
+	BICUBIC
+)
+
+// Heightmap holds the image data of a height texture as well as the maximum height.
+// The image data is stored with floating point precision thus 16 bit and floating point formats keep their precision.
+// Values of integer formats are normalized and thus range from 0 to maximum height,
+// while values of floating point formats are scaled by the maximum height as they are.
+// Positions between pixels are sampled using the filter of the Heightmap.
+// Reading is guarded by a lock since the Heightmap can be sculpted while Chunks are built in the background.
+// A clamped Heightmap does not repeat, pixels outside of the image are clamped to its border instead.
 type Heightmap struct {
-	data      *engine.RawImageData
+	data      *engine.RawFloatImageData
 	maxheight float32
+	filter    HeightmapFilter
+	clamped   bool
+	lock      *sync.RWMutex
 }
 
-// MakeHeightmap creates a Heightmap for the image of the given path and a maximum height.
-func MakeHeightmap(path string, maxheight float32) (Heightmap, error) {
+// MakeHeightmap creates a Heightmap for the image of the given path, a maximum height and the filter used for sampling.
+// Supported are 8 and 16 bit PNGs, JPEGs, raw R16 (.r16) and R32F (.r32) files as well as PFMs.
+func MakeHeightmap(path string, maxheight float32, filter HeightmapFilter) (Heightmap, error) {
 	// load image data
-	data, err := engine.MakeRawImageData(path)
+	data, err := engine.MakeRawFloatImageData(path)
 	if err != nil {
 		return Heightmap{}, err
 	}
@@ -22,6 +46,8 @@ func MakeHeightmap(path string, maxheight float32) (Heightmap, error) {
 	heightmap := Heightmap{
 		data:      &data,
 		maxheight: maxheight,
+		filter:    filter,
+		lock:      &sync.RWMutex{},
 	}
 
 	// preprocess the image data
@@ -30,6 +56,23 @@ func MakeHeightmap(path string, maxheight float32) (Heightmap, error) {
 	return heightmap, nil
 }
 
+// makeClampedHeightmap creates a clamped Heightmap for the image of the given path.
+// The borders of the image are kept as they are since the image is not repeated.
+func makeClampedHeightmap(path string, maxheight float32, filter HeightmapFilter) (Heightmap, error) {
+	data, err := engine.MakeRawFloatImageData(path)
+	if err != nil {
+		return Heightmap{}, err
+	}
+
+	return Heightmap{
+		data:      &data,
+		maxheight: maxheight,
+		filter:    filter,
+		clamped:   true,
+		lock:      &sync.RWMutex{},
+	}, nil
+}
+
 // GetWidth returns the width of the underlying image.
 func (heightmap *Heightmap) GetWidth() int32 {
 	return heightmap.data.GetWidth()
@@ -40,22 +83,198 @@ func (heightmap *Heightmap) GetHeight() int32 {
 	return heightmap.data.GetHeight()
 }
 
-// GetHeight returns the height value at pixel (x,y) within the image.
+// GetBlockHeight returns the height at the position (x,z) in block space.
+// The block space position is mapped onto a sub-pixel position of the image.
+func (heightmap *Heightmap) GetBlockHeight(x, z float32) float32 {
+	px := x * float32(heightmap.GetWidth()-1)
+	pz := z * float32(heightmap.GetHeight()-1)
+
+	heightmap.lock.RLock()
+	defer heightmap.lock.RUnlock()
+	return heightmap.sample(px, pz)
+}
+
+// IsRepeating returns true since the image only covers a single block.
+func (heightmap *Heightmap) IsRepeating() bool {
+	return true
+}
+
+// GetHeightAt returns the height value at pixel (x,y) within the image.
 // x and y have to be in bounds of the image dimensions.
 func (heightmap *Heightmap) GetHeightAt(x, y int32) float32 {
+	heightmap.lock.RLock()
+	defer heightmap.lock.RUnlock()
+
 	// height is between 0 and 1 thus scale with the maximum height
 	height := heightmap.getHeightValue(x, y)
 	return height * heightmap.maxheight
 }
 
+// GetHeightAtF returns the height value at the sub-pixel position (x,y) within the image.
+// The value is interpolated between the surrounding pixels depending on the filter of the Heightmap.
+// Positions outside of the image are wrapped around.
+func (heightmap *Heightmap) GetHeightAtF(x, y float32) float32 {
+	heightmap.lock.RLock()
+	defer heightmap.lock.RUnlock()
+	return heightmap.sample(x, y)
+}
+
+// GetMaxHeight returns the maximum height of the Heightmap.
+func (heightmap *Heightmap) GetMaxHeight() float32 {
+	return heightmap.maxheight
+}
+
+// SetFilter changes the filter that is used for sampling between pixels.
+func (heightmap *Heightmap) SetFilter(filter HeightmapFilter) {
+	heightmap.lock.Lock()
+	defer heightmap.lock.Unlock()
+	heightmap.filter = filter
+}
+
+// IsNormalized returns true if the Heightmap has been loaded from an image of an integer format.
+// The values of such a Heightmap lie between 0 and the maximum height.
+func (heightmap *Heightmap) IsNormalized() bool {
+	return heightmap.data.IsNormalized()
+}
+
+// Save writes the Heightmap to the specified path in the format chosen by the file extension.
+// Floating point heights are only kept by .r32 and .pfm files, PNGs clamp the normalized heights between 0 and 1.
+func (heightmap *Heightmap) Save(path string) error {
+	heightmap.lock.RLock()
+	defer heightmap.lock.RUnlock()
+	return heightmap.data.Save(path)
+}
+
+// SavePNG writes the Heightmap as 16 bit grayscale PNG to the specified path.
+func (heightmap *Heightmap) SavePNG(path string) error {
+	heightmap.lock.RLock()
+	defer heightmap.lock.RUnlock()
+	return heightmap.data.SavePNG(path)
+}
+
+// sample returns the height value at the sub-pixel position (x,y) using the filter of the Heightmap.
+// The caller has to hold the lock.
+func (heightmap *Heightmap) sample(x, y float32) float32 {
+	var height float32
+	switch heightmap.filter {
+	case BILINEAR:
+		height = heightmap.sampleBilinear(x, y)
+	case BICUBIC:
+		height = heightmap.sampleBicubic(x, y)
+	default:
+		height = heightmap.sampleNearest(x, y)
+	}
+	return height * heightmap.maxheight
+}
+
+// sampleNearest returns the normalized height value of the pixel closest to (x,z).
+func (heightmap *Heightmap) sampleNearest(x, z float32) float32 {
+	ix := int32(mathutils.RoundF32(x))
+	iz := int32(mathutils.RoundF32(z))
+	return heightmap.getWrappedHeightValue(ix, iz)
+}
+
+// sampleBilinear returns the normalized height value at (x,z) linearly interpolated between the 4 surrounding pixels.
+func (heightmap *Heightmap) sampleBilinear(x, z float32) float32 {
+	fx := float32(math.Floor(float64(x)))
+	fz := float32(math.Floor(float64(z)))
+	ix := int32(fx)
+	iz := int32(fz)
+	ax := x - fx
+	az := z - fz
+
+	h00 := heightmap.getWrappedHeightValue(ix, iz)
+	h10 := heightmap.getWrappedHeightValue(ix+1, iz)
+	h01 := heightmap.getWrappedHeightValue(ix, iz+1)
+	h11 := heightmap.getWrappedHeightValue(ix+1, iz+1)
+
+	h0 := mathutils.Interpolate(h00, h10, ax)
+	h1 := mathutils.Interpolate(h01, h11, ax)
+	return mathutils.Interpolate(h0, h1, az)
+}
+
+// sampleBicubic returns the normalized height value at (x,z) interpolated between the 16 surrounding pixels.
+// A Catmull-Rom spline is used in x and z direction.
+// Since the spline can overshoot the result is clamped between 0 and 1 for images of integer formats.
+// Floating point images are not clamped since their values can lie outside of that range.
+func (heightmap *Heightmap) sampleBicubic(x, z float32) float32 {
+	fx := float32(math.Floor(float64(x)))
+	fz := float32(math.Floor(float64(z)))
+	ix := int32(fx)
+	iz := int32(fz)
+	ax := x - fx
+	az := z - fz
+
+	// interpolate 4 rows in x direction and then the results in z direction
+	var rows [4]float32
+	var dz int32
+	for dz = -1; dz <= 2; dz++ {
+		rows[dz+1] = catmullRom(
+			heightmap.getWrappedHeightValue(ix-1, iz+dz),
+			heightmap.getWrappedHeightValue(ix, iz+dz),
+			heightmap.getWrappedHeightValue(ix+1, iz+dz),
+			heightmap.getWrappedHeightValue(ix+2, iz+dz),
+			ax,
+		)
+	}
+	height := catmullRom(rows[0], rows[1], rows[2], rows[3], az)
+	if !heightmap.data.IsNormalized() {
+		return height
+	}
+
+	return float32(math.Max(0, math.Min(1, float64(height))))
+}
+
+// clampValue limits a height value of an image of an integer format between 0 and 1.
+// Values of floating point formats are returned unchanged since they can lie outside of that range.
+func (heightmap *Heightmap) clampValue(val float32) float32 {
+	if !heightmap.data.IsNormalized() {
+		return val
+	}
+	return float32(math.Max(0, math.Min(1, float64(val))))
+}
+
+// getWrappedHeightValue returns the height value at pixel (x,z) with the pixel position repeated in all directions.
+// After preprocessing the last row and column equal the first ones, thus the image repeats every width-1 and height-1 pixels.
+// A clamped Heightmap clamps the pixel position to the image instead.
+func (heightmap *Heightmap) getWrappedHeightValue(x, z int32) float32 {
+	if heightmap.clamped {
+		return heightmap.getHeightValue(
+			clampPixel(x, heightmap.data.GetWidth()),
+			clampPixel(z, heightmap.data.GetHeight()),
+		)
+	}
+	return heightmap.getHeightValue(
+		wrapPixel(x, heightmap.data.GetWidth()),
+		wrapPixel(z, heightmap.data.GetHeight()),
+	)
+}
+
 // getHeightValue returns the height value at pixel (x,z)
 func (heightmap *Heightmap) getHeightValue(x, z int32) float32 {
-	return float32(heightmap.data.GetR(x, z)) / 255.0
+	return heightmap.data.GetValue(x, z)
 }
 
-// setHeightValue set the red channel of the image at pixel (x,z) to the value val.
+// setHeightValue set the value of the image at pixel (x,z) to the value val.
 func (heightmap *Heightmap) setHeightValue(x, z int32, val float32) {
-	heightmap.data.SetR(x, z, uint8(val*255.0))
+	heightmap.data.SetValue(x, z, val)
+}
+
+// setWrappedHeightValue sets the value at the pixel (x,z) within the repeating part of the image.
+// The last row and column are kept equal to the first ones.
+func (heightmap *Heightmap) setWrappedHeightValue(x, z int32, val float32) {
+	width := heightmap.data.GetWidth()
+	height := heightmap.data.GetHeight()
+	heightmap.setHeightValue(x, z, val)
+	if x == 0 {
+		heightmap.setHeightValue(width-1, z, val)
+	}
+	if z == 0 {
+		heightmap.setHeightValue(x, height-1, val)
+	}
+	if x == 0 && z == 0 {
+		heightmap.setHeightValue(width-1, height-1, val)
+	}
 }
 
 // preprocessing evens out the height values at the borders of the image
@@ -84,3 +303,38 @@ func (heightmap *Heightmap) preprocessing() {
 		heightmap.setHeightValue(width-1, z, avg)
 	}
 }
+
+// clampPixel clamps the pixel coordinate p to an image side of length size.
+func clampPixel(p, size int32) int32 {
+	if p < 0 {
+		return 0
+	}
+	if p >= size {
+		return size - 1
+	}
+	return p
+}
+
+// wrapPixel repeats the pixel coordinate p for an image side of length size.
+// The last pixel is considered to be the same as the first one.
+func wrapPixel(p, size int32) int32 {
+	period := size - 1
+	if period < 1 {
+		return 0
+	}
+	rp := p % period
+	if rp < 0 {
+		rp += period
+	}
+	return rp
+}
+
+// catmullRom interpolates between p1 and p2 by t using the neighbouring values p0 and p3 as tangent information.
+func catmullRom(p0, p1, p2, p3, t float32) float32 {
+	t2 := t * t
+	t3 := t2 * t
+	return 0.5 * ((2 * p1) +
+		(-p0+p2)*t +
+		(2*p0-5*p1+4*p2-p3)*t2 +
+		(-p0+3*p1-3*p2+p3)*t3)
+}
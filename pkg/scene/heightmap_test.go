@@ -0,0 +1,80 @@
+package scene
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/engine"
+)
+
+// testHeightmapValues are the pixels of a repeating 5x5 image whose last row and column equal the first ones.
+var testHeightmapValues = [][]float32{
+	{0.1, 0.5, 0.2, 0.8, 0.1},
+	{0.4, 0.9, 0.3, 0.6, 0.4},
+	{0.7, 0.2, 1.0, 0.0, 0.7},
+	{0.3, 0.6, 0.5, 0.2, 0.3},
+	{0.1, 0.5, 0.2, 0.8, 0.1},
+}
+
+// makeTestHeightmap creates a Heightmap of the testHeightmapValues with a maximum height of 10.
+func makeTestHeightmap(filter HeightmapFilter) Heightmap {
+	data := engine.MakeEmptyRawFloatImageData(5, 5)
+	for z, row := range testHeightmapValues {
+		for x, val := range row {
+			data.SetValue(int32(x), int32(z), val)
+		}
+	}
+	return Heightmap{data: &data, maxheight: 10, filter: filter, lock: &sync.RWMutex{}}
+}
+
+// expectHeight fails the test if the height is not close to the expected one.
+func expectHeight(t *testing.T, name string, height, expected float32) {
+	t.Helper()
+	if mgl32.Abs(height-expected) > 1e-4 {
+		t.Fatalf("%v: expected height %v but got %v", name, expected, height)
+	}
+}
+
+func TestHeightmapIntegerPixels(t *testing.T) {
+	for _, filter := range []HeightmapFilter{NEAREST, BILINEAR, BICUBIC} {
+		heightmap := makeTestHeightmap(filter)
+		for z := int32(0); z < 5; z++ {
+			for x := int32(0); x < 5; x++ {
+				expectHeight(t, "integer pixel", heightmap.GetHeightAtF(float32(x), float32(z)), 10*testHeightmapValues[z][x])
+			}
+		}
+	}
+}
+
+func TestHeightmapNearest(t *testing.T) {
+	heightmap := makeTestHeightmap(NEAREST)
+
+	// the closest pixel is used instead of the one containing the position
+	expectHeight(t, "rounded up", heightmap.GetHeightAtF(1.6, 0.2), 10*testHeightmapValues[0][2])
+	expectHeight(t, "rounded down", heightmap.GetHeightAtF(1.4, 2.7), 10*testHeightmapValues[3][1])
+}
+
+func TestHeightmapBilinear(t *testing.T) {
+	heightmap := makeTestHeightmap(BILINEAR)
+
+	expectHeight(t, "between two pixels", heightmap.GetHeightAtF(0.5, 1), 10*(0.4+0.9)/2)
+	expectHeight(t, "between four pixels", heightmap.GetHeightAtF(1.5, 1.5), 10*(0.9+0.3+0.2+1.0)/4)
+}
+
+func TestHeightmapWrap(t *testing.T) {
+	// the image repeats every 4 pixels
+	for _, filter := range []HeightmapFilter{NEAREST, BILINEAR, BICUBIC} {
+		heightmap := makeTestHeightmap(filter)
+		for _, pos := range [][2]float32{{1.3, 2.6}, {0.4, 3.6}, {2.25, 0.75}} {
+			expected := heightmap.GetHeightAtF(pos[0], pos[1])
+			expectHeight(t, "left of the image", heightmap.GetHeightAtF(pos[0]-4, pos[1]), expected)
+			expectHeight(t, "below the image", heightmap.GetHeightAtF(pos[0], pos[1]+8), expected)
+		}
+	}
+
+	// the pixels before the first one are the last ones of the repeating part
+	heightmap := makeTestHeightmap(BILINEAR)
+	expectHeight(t, "across the seam", heightmap.GetHeightAtF(-0.5, 0), 10*(0.8+0.1)/2)
+}
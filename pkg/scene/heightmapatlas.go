@@ -0,0 +1,166 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// atlasManifest is the layout of a HeightmapAtlas as stored in the manifest file.
+type atlasManifest struct {
+	Blend float32            `json:"blend"`
+	Maps  []atlasManifestMap `json:"maps"`
+}
+
+// atlasManifestMap is a single heightmap of the manifest placed at the grid cell (X,Z).
+type atlasManifestMap struct {
+	Path        string  `json:"path"`
+	X           int32   `json:"x"`
+	Z           int32   `json:"z"`
+	HeightScale float32 `json:"heightscale"`
+}
+
+// HeightmapAtlas is a HeightSource that does not repeat and is built from several heightmaps laid out on a grid.
+// Every heightmap covers exactly one block, thus the heightmap at the grid cell (x,z) spans from x to x+1 and z to z+1 in block space.
+// Each heightmap has its own height scale which is the maximum height of that heightmap,
+// thus the maximum height of the atlas is the biggest height scale.
+// Neighbouring heightmaps are cross-faded within a band of the blend width in block space on both sides of their shared edge.
+// Cells without a heightmap have a height of 0 and are blended with their neighbours the same way.
+//
+// The layout is described by a JSON manifest, paths are relative to the manifest file:
+//
+//	{
+//	  "blend": 0.05,
+//	  "maps": [
+//	    {"path": "west.png", "x": 0, "z": 0, "heightscale": 300},
+//	    {"path": "east.png", "x": 1, "z": 0, "heightscale": 450}
+//	  ]
+//	}
+type HeightmapAtlas struct {
+	cells     map[atlasCell]*Heightmap
+	blend     float32
+	maxheight float32
+}
+
+// atlasCell is the position of a heightmap on the grid of the HeightmapAtlas.
+type atlasCell struct {
+	x, z int32
+}
+
+// MakeHeightmapAtlas loads the manifest at the given path and all heightmaps it references.
+// All heightmaps are sampled with the specified filter.
+// The blend width is limited to half a block.
+func MakeHeightmapAtlas(path string, filter HeightmapFilter) (HeightmapAtlas, error) {
+	// read manifest
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return HeightmapAtlas{}, err
+	}
+	var manifest atlasManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return HeightmapAtlas{}, fmt.Errorf("Invalid atlas manifest %v: %v", path, err)
+	}
+	if len(manifest.Maps) == 0 {
+		return HeightmapAtlas{}, fmt.Errorf("Atlas manifest %v contains no heightmaps", path)
+	}
+
+	// load all heightmaps relative to the manifest
+	dir := filepath.Dir(path)
+	cells := make(map[atlasCell]*Heightmap)
+	var maxheight float32
+	for _, m := range manifest.Maps {
+		key := atlasCell{m.X, m.Z}
+		if _, ok := cells[key]; ok {
+			return HeightmapAtlas{}, fmt.Errorf("Atlas cell (%v,%v) is used more than once", m.X, m.Z)
+		}
+		mappath := m.Path
+		if !filepath.IsAbs(mappath) {
+			mappath = filepath.Join(dir, mappath)
+		}
+		heightmap, err := makeClampedHeightmap(mappath, m.HeightScale, filter)
+		if err != nil {
+			return HeightmapAtlas{}, err
+		}
+		cells[key] = &heightmap
+		maxheight = mathutils.MaxF32(maxheight, m.HeightScale)
+	}
+
+	return HeightmapAtlas{
+		cells:     cells,
+		blend:     mgl32.Clamp(manifest.Blend, 0, 0.5),
+		maxheight: maxheight,
+	}, nil
+}
+
+// GetBlockHeight returns the height at the position (x,z) in block space.
+// Within the blend band the heights of the heightmap containing the position and its neighbours are cross-faded,
+// such that both heightmaps contribute half of the height right on their shared edge.
+func (atlas *HeightmapAtlas) GetBlockHeight(x, z float32) float32 {
+	cx, wx, nx, nwx := atlas.calcBlendWeights(x)
+	cz, wz, nz, nwz := atlas.calcBlendWeights(z)
+
+	// blend the 4 cells around the position
+	var height, weightsum float32
+	cellsx := [2]int32{cx, nx}
+	cellsz := [2]int32{cz, nz}
+	weightsx := [2]float32{wx, nwx}
+	weightsz := [2]float32{wz, nwz}
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			weight := weightsx[i] * weightsz[j]
+			if weight <= 0 {
+				continue
+			}
+			weightsum += weight
+			if heightmap, ok := atlas.cells[atlasCell{cellsx[i], cellsz[j]}]; ok {
+				// neighbours are sampled outside of their block and thus clamped to their border
+				lx := mgl32.Clamp(x-float32(cellsx[i]), 0, 1)
+				lz := mgl32.Clamp(z-float32(cellsz[j]), 0, 1)
+				height += weight * heightmap.GetBlockHeight(lx, lz)
+			}
+		}
+	}
+	if weightsum == 0 {
+		return 0
+	}
+	return height / weightsum
+}
+
+// GetMaxHeight returns the maximum height of the atlas, which is the biggest height scale of its heightmaps.
+func (atlas *HeightmapAtlas) GetMaxHeight() float32 {
+	return atlas.maxheight
+}
+
+// IsRepeating returns false since the atlas covers the whole world.
+func (atlas *HeightmapAtlas) IsRepeating() bool {
+	return false
+}
+
+// calcBlendWeights returns the cell containing the block space coordinate p and its weight
+// as well as the closest neighbouring cell and its weight.
+// The weight of the cell rises smoothly from 0.5 on the edge to 1 at the blend width away from the edge.
+func (atlas *HeightmapAtlas) calcBlendWeights(p float32) (int32, float32, int32, float32) {
+	cell := int32(mathutils.FloorF32(p))
+	f := p - float32(cell)
+
+	// closest neighbour and distance to the shared edge
+	neighbour := cell + 1
+	dist := 1 - f
+	if f < 0.5 {
+		neighbour = cell - 1
+		dist = f
+	}
+	if atlas.blend <= 0 || dist >= atlas.blend {
+		return cell, 1, neighbour, 0
+	}
+
+	t := dist / atlas.blend
+	weight := 0.5 + 0.5*t*t*(3-2*t)
+	return cell, weight, neighbour, 1 - weight
+}
@@ -0,0 +1,122 @@
+package scene
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/engine"
+)
+
+// makeConstantHeightmap creates a clamped Heightmap of 9x9 pixels with all heights set to val.
+func makeConstantHeightmap(val float32) *Heightmap {
+	data := engine.MakeEmptyRawFloatImageData(9, 9)
+	for z := int32(0); z < 9; z++ {
+		for x := int32(0); x < 9; x++ {
+			data.SetValue(x, z, val)
+		}
+	}
+	return &Heightmap{data: &data, maxheight: 1, filter: BILINEAR, clamped: true, lock: &sync.RWMutex{}}
+}
+
+// expectContinuous fails the test if the height of the atlas jumps by more than maxstep
+// between neighbouring samples along x from minx to maxx at z.
+func expectContinuous(t *testing.T, atlas *HeightmapAtlas, minx, maxx, z, maxstep float32) {
+	t.Helper()
+	const steps = 1000
+	last := atlas.GetBlockHeight(minx, z)
+	for i := 1; i <= steps; i++ {
+		x := minx + (maxx-minx)*float32(i)/steps
+		height := atlas.GetBlockHeight(x, z)
+		if diff := height - last; diff > maxstep || diff < -maxstep {
+			t.Fatalf("expected a continuous height but it jumps from %v to %v at x=%v", last, height, x)
+		}
+		last = height
+	}
+}
+
+func TestHeightmapAtlasEqualHeights(t *testing.T) {
+	atlas := HeightmapAtlas{
+		cells: map[atlasCell]*Heightmap{
+			{0, 0}: makeConstantHeightmap(5),
+			{1, 0}: makeConstantHeightmap(5),
+		},
+		blend:     0.1,
+		maxheight: 5,
+	}
+
+	// two equal heightmaps stay flat across their shared edge
+	for _, x := range []float32{0.5, 0.95, 1, 1.05, 1.5} {
+		expectHeight(t, "equal heightmaps", atlas.GetBlockHeight(x, 0.5), 5)
+	}
+	expectContinuous(t, &atlas, 0.5, 1.5, 0.5, 1e-4)
+}
+
+func TestHeightmapAtlasBlending(t *testing.T) {
+	atlas := HeightmapAtlas{
+		cells: map[atlasCell]*Heightmap{
+			{0, 0}: makeConstantHeightmap(2),
+			{1, 0}: makeConstantHeightmap(6),
+		},
+		blend:     0.1,
+		maxheight: 6,
+	}
+
+	// outside of the blend band each heightmap keeps its height
+	expectHeight(t, "west", atlas.GetBlockHeight(0.5, 0.5), 2)
+	expectHeight(t, "east", atlas.GetBlockHeight(1.5, 0.5), 6)
+	// both heightmaps contribute half on the shared edge
+	expectHeight(t, "edge", atlas.GetBlockHeight(1, 0.5), 4)
+	expectContinuous(t, &atlas, 0.5, 1.5, 0.5, 0.1)
+
+	// cells without a heightmap are blended as height 0
+	expectHeight(t, "empty neighbour", atlas.GetBlockHeight(2, 0.5), 3)
+	expectHeight(t, "empty cell", atlas.GetBlockHeight(2.5, 0.5), 0)
+	expectContinuous(t, &atlas, 1.5, 2.5, 0.5, 0.1)
+}
+
+func TestHeightmapAtlasManifest(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"west.pfm", "east.pfm"} {
+		data := engine.MakeEmptyRawFloatImageData(9, 9)
+		for x := int32(0); x < 9; x++ {
+			data.SetValue(x, 4, 1)
+		}
+		if err := data.Save(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest := filepath.Join(dir, "atlas.json")
+	content := `{"blend": 0.8, "maps": [
+		{"path": "west.pfm", "x": 0, "z": 0, "heightscale": 300},
+		{"path": "east.pfm", "x": 1, "z": 0, "heightscale": 450}
+	]}`
+	if err := ioutil.WriteFile(manifest, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	atlas, err := MakeHeightmapAtlas(manifest, BILINEAR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var source HeightSource = &atlas
+	bounded, ok := source.(BoundedHeightSource)
+	if !ok || bounded.GetMaxHeight() != 450 {
+		t.Fatalf("expected the atlas to be bounded by the biggest height scale")
+	}
+	if atlas.blend != 0.5 {
+		t.Fatalf("expected the blend width to be limited to 0.5 but got %v", atlas.blend)
+	}
+	expectHeight(t, "height scale", atlas.GetBlockHeight(1.5, 0.5), 450)
+
+	// a cell may only be used once
+	content = `{"maps": [{"path": "west.pfm", "x": 0, "z": 0}, {"path": "east.pfm", "x": 0, "z": 0}]}`
+	if err := ioutil.WriteFile(manifest, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MakeHeightmapAtlas(manifest, BILINEAR); err == nil {
+		t.Fatal("expected an error for a cell that is used twice")
+	}
+}
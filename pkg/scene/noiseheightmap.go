@@ -0,0 +1,114 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"math"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// NoiseType selects the basis function of a NoiseHeightmap.
+type NoiseType int
+
+const (
+	PERLIN NoiseType = iota
+	SIMPLEX
+)
+
+// offsets of the two noise lookups used for the domain warp.
+// They decorrelate the warp from the height itself.
+const (
+	warpOffsetX = 17.3
+	warpOffsetZ = 41.7
+)
+
+// NoiseHeightmap is a procedural HeightSource that does not repeat.
+// The height is calculated by fractal brownian motion (fBm) of the chosen basis noise.
+// Each octave multiplies the frequency by the lacunarity and the amplitude by the gain.
+// Optionally the position is displaced by another fBm lookup before sampling, which is called domain warping.
+// The same seed always yields the same heights.
+type NoiseHeightmap struct {
+	noise      mathutils.Noise
+	noisetype  NoiseType
+	octaves    int
+	frequency  float64
+	lacunarity float64
+	gain       float64
+	warp       float64
+	maxheight  float32
+}
+
+// MakeNoiseHeightmap constructs a NoiseHeightmap from a seed and the fBm parameters.
+// The frequency is the number of features of the first octave per block.
+// Octaves specifies how many layers of noise are summed up.
+// The lacunarity is the frequency multiplier and the gain is the amplitude multiplier between two octaves.
+// Warp specifies the strength of the domain warp in block space, a value of 0 disables it.
+// The maxheight is the maximum height of the terrain.
+func MakeNoiseHeightmap(seed int64, noisetype NoiseType, octaves int, frequency, lacunarity, gain, warp, maxheight float32) NoiseHeightmap {
+	if octaves < 1 {
+		octaves = 1
+	}
+
+	return NoiseHeightmap{
+		noise:      mathutils.MakeNoise(seed),
+		noisetype:  noisetype,
+		octaves:    octaves,
+		frequency:  float64(frequency),
+		lacunarity: float64(lacunarity),
+		gain:       float64(gain),
+		warp:       float64(warp),
+		maxheight:  maxheight,
+	}
+}
+
+// GetBlockHeight returns the height at the position (x,z) in block space.
+// Values range from 0 to the maximum height.
+func (heightmap *NoiseHeightmap) GetBlockHeight(x, z float32) float32 {
+	px := float64(x) * heightmap.frequency
+	pz := float64(z) * heightmap.frequency
+
+	// displace the position by a second noise lookup
+	if heightmap.warp != 0 {
+		wx := heightmap.fbm(px+warpOffsetX, pz+warpOffsetZ)
+		wz := heightmap.fbm(px-warpOffsetZ, pz-warpOffsetX)
+		px += heightmap.warp * heightmap.frequency * wx
+		pz += heightmap.warp * heightmap.frequency * wz
+	}
+
+	// map the noise from -1 to 1 onto 0 to 1
+	h := (heightmap.fbm(px, pz) + 1) / 2
+	h = math.Max(0, math.Min(1, h))
+
+	return float32(h) * heightmap.maxheight
+}
+
+// IsRepeating returns false since noise is defined everywhere.
+func (heightmap *NoiseHeightmap) IsRepeating() bool {
+	return false
+}
+
+// fbm sums up all octaves of the basis noise at (x,z).
+// The result is normalized by the sum of all amplitudes and lies approximately between -1 and 1.
+func (heightmap *NoiseHeightmap) fbm(x, z float64) float64 {
+	var (
+		sum       float64 = 0.0
+		amplitude float64 = 1.0
+		norm      float64 = 0.0
+		frequency float64 = 1.0
+	)
+	for i := 0; i < heightmap.octaves; i++ {
+		sum += amplitude * heightmap.basis(x*frequency, z*frequency)
+		norm += amplitude
+		amplitude *= heightmap.gain
+		frequency *= heightmap.lacunarity
+	}
+	return sum / norm
+}
+
+// basis evaluates the selected noise function at (x,z).
+func (heightmap *NoiseHeightmap) basis(x, z float64) float64 {
+	if heightmap.noisetype == SIMPLEX {
+		return heightmap.noise.Simplex(x, z)
+	}
+	return heightmap.noise.Perlin(x, z)
+}
@@ -0,0 +1,46 @@
+package scene
+
+import "testing"
+
+// sampleNoiseHeightmap returns the heights of the heightmap on a regular grid around the origin.
+func sampleNoiseHeightmap(heightmap *NoiseHeightmap) []float32 {
+	var heights []float32
+	for z := -20; z <= 20; z++ {
+		for x := -20; x <= 20; x++ {
+			heights = append(heights, heightmap.GetBlockHeight(float32(x)*0.137, float32(z)*0.137))
+		}
+	}
+	return heights
+}
+
+func TestNoiseHeightmapSameSeed(t *testing.T) {
+	for _, noisetype := range []NoiseType{PERLIN, SIMPLEX} {
+		a := MakeNoiseHeightmap(42, noisetype, 6, 4.0, 2.0, 0.5, 0.2, 300.0)
+		b := MakeNoiseHeightmap(42, noisetype, 6, 4.0, 2.0, 0.5, 0.2, 300.0)
+		ha := sampleNoiseHeightmap(&a)
+		hb := sampleNoiseHeightmap(&b)
+		for i := range ha {
+			if ha[i] != hb[i] {
+				t.Fatalf("noise type %v: sample %v differs for the same seed, %v != %v", noisetype, i, ha[i], hb[i])
+			}
+		}
+	}
+}
+
+func TestNoiseHeightmapDifferentSeeds(t *testing.T) {
+	for _, noisetype := range []NoiseType{PERLIN, SIMPLEX} {
+		a := MakeNoiseHeightmap(42, noisetype, 6, 4.0, 2.0, 0.5, 0.2, 300.0)
+		b := MakeNoiseHeightmap(43, noisetype, 6, 4.0, 2.0, 0.5, 0.2, 300.0)
+		ha := sampleNoiseHeightmap(&a)
+		hb := sampleNoiseHeightmap(&b)
+		differences := 0
+		for i := range ha {
+			if ha[i] != hb[i] {
+				differences++
+			}
+		}
+		if differences < len(ha)/2 {
+			t.Fatalf("noise type %v: only %v of %v samples differ for different seeds", noisetype, differences, len(ha))
+		}
+	}
+}
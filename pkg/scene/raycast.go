@@ -0,0 +1,155 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// raycastepsilon is the tolerance used when checking whether a hit lies within a Tile.
+const raycastepsilon = 1e-4
+
+// RaycastHit describes where a ray hit the Terrain.
+// Position is the hit point and Normal the normal of the triangle that was hit.
+// Lod is the level of detail of the Chunk that was hit.
+// ChunkX and ChunkZ are the coordinate of the Chunk and TileX and TileZ the coordinate of the Tile within that Chunk, both in the grid of that level.
+// Distance is the distance from the ray origin to the hit point.
+type RaycastHit struct {
+	Position mgl32.Vec3
+	Normal   mgl32.Vec3
+	Lod      int32
+	ChunkX   int32
+	ChunkZ   int32
+	TileX    int32
+	TileZ    int32
+	Distance float32
+}
+
+// Raycast shoots a ray from origin in direction dir and returns the first hit with the Terrain that is at most maxdist away.
+// The ray marches across the Tile grid in the x-z plane and intersects the two triangle planes of each Tile it passes.
+// Only loaded Chunks are considered, Tiles of Chunks that are not loaded are skipped.
+// The second return value is false if nothing has been hit.
+func (terrain *Terrain) Raycast(origin, dir mgl32.Vec3, maxdist float32) (RaycastHit, bool) {
+	if dir.Len() == 0 || maxdist <= 0 {
+		return RaycastHit{}, false
+	}
+	dir = dir.Normalize()
+
+	// start at the Tile that contains the origin
+	ts := terrain.tilesize
+	gx := int32(mathutils.FloorF32(origin.X() / ts))
+	gz := int32(mathutils.FloorF32(origin.Z() / ts))
+
+	// setup the grid traversal in x and z direction
+	stepx, tmaxx, tdeltax := initTraversal(origin.X(), dir.X(), gx, ts)
+	stepz, tmaxz, tdeltaz := initTraversal(origin.Z(), dir.Z(), gz, ts)
+
+	var tenter float32 = 0.0
+	for {
+		texit := mathutils.MinF32(mathutils.MinF32(tmaxx, tmaxz), maxdist)
+
+		// check both triangles of the current Tile
+		if hit, ok := terrain.intersectTile(origin, dir, gx, gz, tenter, texit); ok {
+			return hit, true
+		}
+		if texit >= maxdist {
+			return RaycastHit{}, false
+		}
+
+		// advance to the next Tile
+		tenter = texit
+		if tmaxx < tmaxz {
+			gx += stepx
+			tmaxx += tdeltax
+		} else {
+			gz += stepz
+			tmaxz += tdeltaz
+		}
+	}
+}
+
+// intersectTile intersects the ray with the Tile at the global Tile coordinate (gx,gz) of level 0.
+// If the area is covered by a coarser Chunk the coarser Tile is intersected instead.
+// Only hits with a distance between tenter and texit are reported, which is the part of the ray above the Tile.
+func (terrain *Terrain) intersectTile(origin, dir mgl32.Vec3, gx, gz int32, tenter, texit float32) (RaycastHit, bool) {
+	// find the Chunk of the Tile
+	chunk, tx, tz, ok := terrain.findChunk(gx, gz)
+	if !ok {
+		return RaycastHit{}, false
+	}
+	res := terrain.cf.chunkresolution
+	tri1, tri2 := chunk.getTilePlanes(tz*res + tx)
+
+	// corner of the Tile in the grid of its level
+	tilesize := terrain.tilesize * float32(int32(1)<<uint(chunk.lod))
+	minx := float32(chunk.x*res+tx) * tilesize
+	minz := float32(chunk.z*res+tz) * tilesize
+
+	// the closer hit of both triangles wins
+	var (
+		best  RaycastHit
+		found bool
+	)
+	for i, plane := range [2]mgl32.Vec4{tri1, tri2} {
+		normal := plane.Vec3()
+		denom := normal.Dot(dir)
+		if mathutils.AbsF32(denom) < 1e-8 {
+			continue
+		}
+		t := -(normal.Dot(origin) + plane.W()) / denom
+		if t < 0 || t < tenter-raycastepsilon || t > texit+raycastepsilon {
+			continue
+		}
+
+		// the upper left triangle covers rx <= rz and the lower right one rx >= rz
+		pos := origin.Add(dir.Mul(t))
+		rx := pos.X() - minx
+		rz := pos.Z() - minz
+		if (i == 0 && rx > rz+raycastepsilon) || (i == 1 && rx < rz-raycastepsilon) {
+			continue
+		}
+
+		if !found || t < best.Distance {
+			best = RaycastHit{
+				Position: pos,
+				Normal:   normal,
+				Lod:      chunk.lod,
+				ChunkX:   chunk.x,
+				ChunkZ:   chunk.z,
+				TileX:    tx,
+				TileZ:    tz,
+				Distance: t,
+			}
+			found = true
+		}
+	}
+
+	return best, found
+}
+
+// initTraversal returns the step direction, the distance along the ray to the first Tile border
+// and the distance between two Tile borders for one axis of the grid traversal.
+// The position p and the direction d are the components of the ray origin and direction along that axis
+// and g is the coordinate of the Tile containing the origin.
+func initTraversal(p, d float32, g int32, tilesize float32) (int32, float32, float32) {
+	switch {
+	case d > 0:
+		return 1, (float32(g+1)*tilesize - p) / d, tilesize / d
+	case d < 0:
+		return -1, (float32(g)*tilesize - p) / d, -tilesize / d
+	default:
+		return 0, math.MaxFloat32, math.MaxFloat32
+	}
+}
+
+// floorDiv divides a by b rounding towards negative infinity.
+func floorDiv(a, b int32) int32 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,147 @@
+package scene
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// saddleSource is a HeightSource describing the saddle y = scale*x*z in block space, thus the two triangles of a Tile differ.
+type saddleSource struct {
+	scale float32
+}
+
+func (source *saddleSource) GetBlockHeight(x, z float32) float32 {
+	return source.scale * x * z
+}
+
+func (source *saddleSource) IsRepeating() bool {
+	return false
+}
+
+// makeRaycastTerrain creates a Terrain without any GPU resources whose 2x2 Chunks around the origin are loaded.
+func makeRaycastTerrain(source HeightSource) *Terrain {
+	terrain := makeQueryTerrain(source, REPEAT)
+	for z := int32(0); z < 2; z++ {
+		for x := int32(0); x < 2; x++ {
+			terrain.chunks[makeKey(x, z)] = terrain.cf.MakeChunk(x, z)
+		}
+	}
+	return terrain
+}
+
+func TestRaycastHit(t *testing.T) {
+	terrain := makeRaycastTerrain(&planeSource{10, 0, 0})
+
+	// straight down onto the flat terrain
+	hit, ok := terrain.Raycast(mgl32.Vec3{5.5, 50, 21.5}, mgl32.Vec3{0, -1, 0}, 100)
+	if !ok {
+		t.Fatal("expected the ray to hit the terrain")
+	}
+	if hit.Position.Sub(mgl32.Vec3{5.5, 10, 21.5}).Len() > 1e-3 || mgl32.Abs(hit.Distance-40) > 1e-3 {
+		t.Fatalf("expected a hit at (5.5,10,21.5) in a distance of 40 but got %v in %v", hit.Position, hit.Distance)
+	}
+	if hit.ChunkX != 0 || hit.ChunkZ != 1 || hit.TileX != 2 || hit.TileZ != 2 || hit.Lod != 0 {
+		t.Fatalf("expected tile (2,2) of chunk (0,1) but got tile (%v,%v) of chunk (%v,%v) of level %v", hit.TileX, hit.TileZ, hit.ChunkX, hit.ChunkZ, hit.Lod)
+	}
+	if normal := hit.Normal.Normalize(); mgl32.Abs(normal.Y()) < 1-1e-4 {
+		t.Fatalf("expected a vertical normal but got %v", hit.Normal)
+	}
+
+	// diagonally across several tiles
+	origin := mgl32.Vec3{1, 30, 1}
+	dir := mgl32.Vec3{1, -1, 0.5}
+	hit, ok = terrain.Raycast(origin, dir, 100)
+	if !ok {
+		t.Fatal("expected the diagonal ray to hit the terrain")
+	}
+	expected := origin.Add(dir.Mul(20))
+	if hit.Position.Sub(expected).Len() > 1e-3 || mgl32.Abs(hit.Distance-dir.Len()*20) > 1e-3 {
+		t.Fatalf("expected a hit at %v but got %v", expected, hit.Position)
+	}
+}
+
+func TestRaycastMiss(t *testing.T) {
+	terrain := makeRaycastTerrain(&planeSource{10, 0, 0})
+
+	for _, ray := range [][2]mgl32.Vec3{
+		{{5, 50, 5}, {0, 1, 0}},    // pointing away from the terrain
+		{{5, 50, 5}, {1, 0, 0}},    // parallel to the terrain
+		{{5, 5, 5}, {0, -1, 0}},    // starting below the terrain
+		{{50, 50, 5}, {0, -1, 0}},  // above a Chunk that is not loaded
+		{{5, 50, 5}, {0, 0, 0}},    // without a direction
+		{{-20, 50, 5}, {0, -1, 0}}, // left of the loaded Chunks
+	} {
+		if hit, ok := terrain.Raycast(ray[0], ray[1], 1000); ok {
+			t.Fatalf("expected the ray from %v in direction %v to miss but it hit %v", ray[0], ray[1], hit.Position)
+		}
+	}
+}
+
+func TestRaycastMaxDist(t *testing.T) {
+	terrain := makeRaycastTerrain(&planeSource{10, 0, 0})
+
+	// the terrain is 40 units below the origin
+	if _, ok := terrain.Raycast(mgl32.Vec3{5, 50, 5}, mgl32.Vec3{0, -1, 0}, 39.9); ok {
+		t.Fatal("expected no hit beyond the maximum distance")
+	}
+	if _, ok := terrain.Raycast(mgl32.Vec3{5, 50, 5}, mgl32.Vec3{0, -1, 0}, 40.1); !ok {
+		t.Fatal("expected a hit within the maximum distance")
+	}
+	if _, ok := terrain.Raycast(mgl32.Vec3{5, 50, 5}, mgl32.Vec3{0, -1, 0}, 0); ok {
+		t.Fatal("expected no hit for a maximum distance of 0")
+	}
+
+	// the hit far away along a shallow ray is only found with a long enough maximum distance
+	dir := mgl32.Vec3{1, -0.5, 0}
+	if _, ok := terrain.Raycast(mgl32.Vec3{1, 20, 5}, dir, 15); ok {
+		t.Fatal("expected no hit for the shallow ray beyond the maximum distance")
+	}
+	if hit, ok := terrain.Raycast(mgl32.Vec3{1, 20, 5}, dir, 25); !ok || mgl32.Abs(hit.Position.X()-21) > 1e-3 {
+		t.Fatalf("expected the shallow ray to hit at x = 21 but got %v %v", hit.Position, ok)
+	}
+}
+
+func TestRaycastTriangleSelection(t *testing.T) {
+	terrain := makeRaycastTerrain(&saddleSource{5000})
+
+	// hits on both triangles of the Tiles agree with the surface queries
+	for _, pos := range []mgl32.Vec2{{4.3, 4.9}, {4.9, 4.3}, {11.2, 27.7}, {27.7, 11.2}, {30.5, 30.1}, {17.1, 17.9}} {
+		hit, ok := terrain.Raycast(mgl32.Vec3{pos.X(), 500, pos.Y()}, mgl32.Vec3{0, -1, 0}, 1000)
+		if !ok {
+			t.Fatalf("expected the ray at %v to hit the terrain", pos)
+		}
+		sample, err := terrain.GetSurface(pos.X(), pos.Y())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mgl32.Abs(hit.Position.Y()-sample.Height) > 1e-2 {
+			t.Fatalf("expected the hit at %v to have the height %v but got %v", pos, sample.Height, hit.Position.Y())
+		}
+		normal := hit.Normal.Normalize()
+		if normal.Y() < 0 {
+			normal = normal.Mul(-1)
+		}
+		if normal.Sub(sample.Normal).Len() > 1e-3 {
+			t.Fatalf("expected the hit at %v to have the normal %v but got %v", pos, sample.Normal, normal)
+		}
+
+		// the upper left triangle covers rx < rz and the lower right one rx >= rz
+		chunk := terrain.chunks[makeKey(hit.ChunkX, hit.ChunkZ)]
+		tri1, tri2 := chunk.getTilePlanes(hit.TileZ*terrain.cf.chunkresolution + hit.TileX)
+		rx := pos.X() - 2*mathutils.FloorF32(pos.X()/2)
+		rz := pos.Y() - 2*mathutils.FloorF32(pos.Y()/2)
+		plane, other := tri2, tri1
+		if rx < rz {
+			plane, other = tri1, tri2
+		}
+		if d := plane.Vec3().Dot(hit.Position) + plane.W(); mgl32.Abs(d) > 1e-2 {
+			t.Fatalf("expected the hit at %v to lie on the plane %v but it is %v away", pos, plane, d)
+		}
+		if plane.Sub(other).Len() < 1e-3 {
+			t.Fatalf("expected the triangles of the tile at %v to differ", pos)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"errors"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// BrushType specifies how a Brush changes the heights of a Heightmap.
+type BrushType int
+
+const (
+	RAISE BrushType = iota
+	LOWER
+	SMOOTH
+	FLATTEN
+	NOISE
+)
+
+const (
+	// sculptundocount is the maximum number of strokes that can be undone.
+	sculptundocount = 64
+	// sculptnoisefrequency is the frequency of the NOISE brush in features per pixel.
+	sculptnoisefrequency = 0.15
+	// sculptfiltermargin is the number of pixels the filters of the Heightmap read around a position.
+	sculptfiltermargin = 2
+)
+
+// Brush describes a sculpting operation with a radius in world units.
+// RAISE and LOWER move the heights by strength world units per application at the center of the Brush.
+// SMOOTH, FLATTEN and NOISE use the strength between 0 and 1 as blend factor respectively as amplitude in world units for NOISE.
+// FLATTEN pulls all heights towards the height at the center of the Brush at the beginning of a stroke.
+// The influence of the Brush falls off smoothly towards its border.
+type Brush struct {
+	brushtype BrushType
+	radius    float32
+	strength  float32
+}
+
+// MakeBrush creates a Brush of the given type, radius and strength.
+func MakeBrush(brushtype BrushType, radius, strength float32) Brush {
+	return Brush{
+		brushtype: brushtype,
+		radius:    radius,
+		strength:  strength,
+	}
+}
+
+// pixelRect is a rectangle in pixel coordinates of a Heightmap.
+// The coordinates are not wrapped and can thus be outside of the image.
+type pixelRect struct {
+	minx float32
+	minz float32
+	maxx float32
+	maxz float32
+}
+
+// union returns the smallest pixelRect containing both rectangles.
+func (rect pixelRect) union(other pixelRect) pixelRect {
+	return pixelRect{
+		minx: mathutils.MinF32(rect.minx, other.minx),
+		minz: mathutils.MinF32(rect.minz, other.minz),
+		maxx: mathutils.MaxF32(rect.maxx, other.maxx),
+		maxz: mathutils.MaxF32(rect.maxz, other.maxz),
+	}
+}
+
+// heightmapEdit stores the values of all pixels changed by one stroke before and after the stroke.
+type heightmapEdit struct {
+	before map[int32]float32
+	after  map[int32]float32
+	rect   pixelRect
+	target float32
+	empty  bool
+}
+
+// Sculptor applies Brushes to a Heightmap and keeps the history of all strokes for undo and redo.
+// All applications of Brushes between two calls to EndStroke form one stroke.
+type Sculptor struct {
+	heightmap *Heightmap
+	noise     mathutils.Noise
+	stroke    *heightmapEdit
+	undo      []heightmapEdit
+	redo      []heightmapEdit
+}
+
+// MakeSculptor creates a Sculptor for the Heightmap.
+// The seed decides the pattern of the NOISE brush.
+func MakeSculptor(heightmap *Heightmap, seed int64) Sculptor {
+	return Sculptor{
+		heightmap: heightmap,
+		noise:     mathutils.MakeNoise(seed),
+		stroke:    nil,
+		undo:      nil,
+		redo:      nil,
+	}
+}
+
+// Apply applies the Brush at the pixel position (px,pz) with a radius in pixels.
+// Returns the rectangle of pixels that have been changed.
+func (sculptor *Sculptor) Apply(brush Brush, px, pz, radius float32) pixelRect {
+	heightmap := sculptor.heightmap
+	heightmap.lock.Lock()
+	defer heightmap.lock.Unlock()
+
+	width := heightmap.data.GetWidth()
+	height := heightmap.data.GetHeight()
+
+	// start a new stroke
+	if sculptor.stroke == nil {
+		sculptor.stroke = &heightmapEdit{
+			before: map[int32]float32{},
+			after:  map[int32]float32{},
+			target: heightmap.sample(px, pz) / heightmap.maxheight,
+			empty:  true,
+		}
+	}
+	stroke := sculptor.stroke
+
+	// strength relative to the maximum height
+	strength := brush.strength
+	if brush.brushtype != SMOOTH && brush.brushtype != FLATTEN {
+		strength /= heightmap.maxheight
+	}
+
+	// calculate all new values before writing them such that smoothing reads unchanged neighbours
+	r := int32(mathutils.CeilF32(radius))
+	cx := int32(mathutils.FloorF32(px))
+	cz := int32(mathutils.FloorF32(pz))
+	var (
+		xs, zs []int32
+		vals   []float32
+	)
+	for iz := cz - r; iz <= cz+r+1; iz++ {
+		for ix := cx - r; ix <= cx+r+1; ix++ {
+			dx := float32(ix) - px
+			dz := float32(iz) - pz
+			dist := mathutils.SqrtF32(dx*dx + dz*dz)
+			if dist >= radius {
+				continue
+			}
+			w := 1 - (dist*dist)/(radius*radius)
+			w = w * w
+
+			x := wrapPixel(ix, width)
+			z := wrapPixel(iz, height)
+			old := heightmap.getHeightValue(x, z)
+			val := old
+			switch brush.brushtype {
+			case RAISE:
+				val = old + strength*w
+			case LOWER:
+				val = old - strength*w
+			case SMOOTH:
+				val = mathutils.Interpolate(old, sculptor.average(ix, iz), mgl32.Clamp(strength*w, 0, 1))
+			case FLATTEN:
+				val = mathutils.Interpolate(old, stroke.target, mgl32.Clamp(strength*w, 0, 1))
+			case NOISE:
+				n := sculptor.noise.Perlin(float64(ix)*sculptnoisefrequency, float64(iz)*sculptnoisefrequency)
+				val = old + strength*w*float32(n)
+			}
+			xs = append(xs, x)
+			zs = append(zs, z)
+			vals = append(vals, heightmap.clampValue(val))
+		}
+	}
+
+	// write the new values and record them in the stroke
+	for i, val := range vals {
+		idx := zs[i]*width + xs[i]
+		if _, ok := stroke.before[idx]; !ok {
+			stroke.before[idx] = heightmap.getHeightValue(xs[i], zs[i])
+		}
+		stroke.after[idx] = val
+		heightmap.setWrappedHeightValue(xs[i], zs[i], val)
+	}
+
+	rect := pixelRect{
+		minx: float32(cx - r),
+		minz: float32(cz - r),
+		maxx: float32(cx + r + 1),
+		maxz: float32(cz + r + 1),
+	}
+	if stroke.empty {
+		stroke.rect = rect
+		stroke.empty = false
+	} else {
+		stroke.rect = stroke.rect.union(rect)
+	}
+	return rect
+}
+
+// EndStroke finishes the current stroke and adds it to the history.
+// Finishing a stroke discards all strokes that could have been redone.
+func (sculptor *Sculptor) EndStroke() {
+	if sculptor.stroke == nil {
+		return
+	}
+	if !sculptor.stroke.empty {
+		sculptor.undo = append(sculptor.undo, *sculptor.stroke)
+		if len(sculptor.undo) > sculptundocount {
+			sculptor.undo = sculptor.undo[1:]
+		}
+		sculptor.redo = nil
+	}
+	sculptor.stroke = nil
+}
+
+// Undo reverts the last stroke and returns the rectangle of pixels that have been changed.
+// The second return value is false if there is nothing to undo.
+func (sculptor *Sculptor) Undo() (pixelRect, bool) {
+	sculptor.EndStroke()
+	if len(sculptor.undo) == 0 {
+		return pixelRect{}, false
+	}
+	edit := sculptor.undo[len(sculptor.undo)-1]
+	sculptor.undo = sculptor.undo[:len(sculptor.undo)-1]
+	sculptor.redo = append(sculptor.redo, edit)
+	sculptor.write(edit.before)
+	return edit.rect, true
+}
+
+// Redo applies the last reverted stroke again and returns the rectangle of pixels that have been changed.
+// The second return value is false if there is nothing to redo.
+func (sculptor *Sculptor) Redo() (pixelRect, bool) {
+	sculptor.EndStroke()
+	if len(sculptor.redo) == 0 {
+		return pixelRect{}, false
+	}
+	edit := sculptor.redo[len(sculptor.redo)-1]
+	sculptor.redo = sculptor.redo[:len(sculptor.redo)-1]
+	sculptor.undo = append(sculptor.undo, edit)
+	sculptor.write(edit.after)
+	return edit.rect, true
+}
+
+// write sets the pixels with the indices of values to their values.
+func (sculptor *Sculptor) write(values map[int32]float32) {
+	heightmap := sculptor.heightmap
+	heightmap.lock.Lock()
+	defer heightmap.lock.Unlock()
+
+	width := heightmap.data.GetWidth()
+	for idx, val := range values {
+		heightmap.setWrappedHeightValue(idx%width, idx/width, val)
+	}
+}
+
+// average returns the mean of the 3x3 pixels around the pixel (x,z).
+func (sculptor *Sculptor) average(x, z int32) float32 {
+	var sum float32 = 0.0
+	var dx, dz int32
+	for dz = -1; dz <= 1; dz++ {
+		for dx = -1; dx <= 1; dx++ {
+			sum += sculptor.heightmap.getWrappedHeightValue(x+dx, z+dz)
+		}
+	}
+	return sum / 9.0
+}
+
+// Sculpt applies the Brush at the position pos on the Terrain.
+// Only the Chunks that use the changed heights are rebuilt.
+// Consecutive calls form one stroke until EndStroke is called.
+// Returns an error if the HeightSource of the Terrain is not a Heightmap.
+func (terrain *Terrain) Sculpt(brush Brush, pos mgl32.Vec3) error {
+	if terrain.sculptor == nil {
+		return errors.New("Terrain can only be sculpted with a Heightmap as height source")
+	}
+
+	px, pz := terrain.getPixelPos(pos.X(), pos.Z())
+	radius := brush.radius * terrain.getPixelsPerUnit()
+	rect := terrain.sculptor.Apply(brush, px, pz, radius)
+	terrain.rebuild(rect)
+	return nil
+}
+
+// EndStroke finishes the current sculpting stroke such that it can be undone as a whole.
+func (terrain *Terrain) EndStroke() {
+	if terrain.sculptor != nil {
+		terrain.sculptor.EndStroke()
+	}
+}
+
+// Undo reverts the last sculpting stroke.
+// Returns false if there is nothing to undo.
+func (terrain *Terrain) Undo() bool {
+	if terrain.sculptor == nil {
+		return false
+	}
+	rect, ok := terrain.sculptor.Undo()
+	if ok {
+		terrain.rebuild(rect)
+	}
+	return ok
+}
+
+// Redo applies the last reverted sculpting stroke again.
+// Returns false if there is nothing to redo.
+func (terrain *Terrain) Redo() bool {
+	if terrain.sculptor == nil {
+		return false
+	}
+	rect, ok := terrain.sculptor.Redo()
+	if ok {
+		terrain.rebuild(rect)
+	}
+	return ok
+}
+
+// SaveHeightmap writes the sculpted Heightmap to the specified path in the format chosen by the file extension.
+// Use .r32 or .pfm to keep the heights of floating point Heightmaps, all other files are written as 16 bit grayscale PNG.
+func (terrain *Terrain) SaveHeightmap(path string) error {
+	if terrain.sculptor == nil {
+		return errors.New("Terrain can only be saved with a Heightmap as height source")
+	}
+	return terrain.sculptor.heightmap.Save(path)
+}
+
+// rebuild marks all loaded Chunks that read heights from the pixels within rect to be rebuilt by the ChunkLoader.
+// Until a rebuilt Chunk arrives the old one stays in its slot, thus sculpting doesn't build Chunks on the GL thread.
+// Cached Chunks and Chunks that are currently built in the background are discarded if they read from rect as well.
+func (terrain *Terrain) rebuild(rect pixelRect) {
+	heightmap := terrain.sculptor.heightmap
+	width := float32(heightmap.GetWidth() - 1)
+	height := float32(heightmap.GetHeight() - 1)
+
+	// the filters read neighbouring pixels as well
+	rect.minx -= sculptfiltermargin
+	rect.minz -= sculptfiltermargin
+	rect.maxx += sculptfiltermargin
+	rect.maxz += sculptfiltermargin
+
+	touches := func(chunk *Chunk) bool {
+		return terrain.tf.samplesPixels(chunk.lod, chunk.x, chunk.z, terrain.cf.chunkresolution, rect, width, height)
+	}
+
+	for key, chunk := range terrain.chunks {
+		if touches(&chunk) {
+			terrain.stale[key] = true
+		}
+	}
+	terrain.cache.InvalidateFunc(touches)
+	terrain.loader.DiscardFunc(touches)
+}
+
+// getPixelPos maps the world position (x,z) onto the pixel position of the Heightmap.
+func (terrain *Terrain) getPixelPos(x, z float32) (float32, float32) {
+	heightmap := terrain.sculptor.heightmap
+	vx := terrain.tf.wrapTileCoordF(x / terrain.tilesize)
+	vz := terrain.tf.wrapTileCoordF(z / terrain.tilesize)
+	last := float32(terrain.tf.tilesperblock - 1)
+	return vx / last * float32(heightmap.GetWidth()-1), vz / last * float32(heightmap.GetHeight()-1)
+}
+
+// getPixelsPerUnit returns the number of pixels of the Heightmap per world unit.
+func (terrain *Terrain) getPixelsPerUnit() float32 {
+	last := float32(terrain.tf.tilesperblock - 1)
+	return float32(terrain.sculptor.heightmap.GetWidth()-1) / (last * terrain.tilesize)
+}
@@ -0,0 +1,118 @@
+package scene
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/engine"
+)
+
+// makeTestSculptor creates a Sculptor for a flat floating point Heightmap of 33x33 pixels with a maximum height of 10.
+func makeTestSculptor() Sculptor {
+	data := engine.MakeEmptyRawFloatImageData(33, 33)
+	heightmap := Heightmap{data: &data, maxheight: 10, filter: NEAREST, lock: &sync.RWMutex{}}
+	return MakeSculptor(&heightmap, 0)
+}
+
+func TestSculptorStrokeGrouping(t *testing.T) {
+	sculptor := makeTestSculptor()
+	brush := MakeBrush(RAISE, 4, 1)
+
+	// all applications until EndStroke form one stroke
+	sculptor.Apply(brush, 16, 16, 4)
+	sculptor.Apply(brush, 16, 16, 4)
+	sculptor.Apply(brush, 20, 16, 4)
+	sculptor.EndStroke()
+	if len(sculptor.undo) != 1 {
+		t.Fatalf("expected 1 stroke but got %v", len(sculptor.undo))
+	}
+	raised := sculptor.heightmap.GetHeightAt(16, 16)
+	expectHeight(t, "two applications", raised, 2)
+
+	// ending a stroke without applications doesn't add a stroke
+	sculptor.EndStroke()
+	if len(sculptor.undo) != 1 {
+		t.Fatalf("expected 1 stroke after an empty stroke but got %v", len(sculptor.undo))
+	}
+
+	// undo reverts the whole stroke and redo applies it again
+	rect, ok := sculptor.Undo()
+	if !ok {
+		t.Fatalf("expected undo to succeed")
+	}
+	if rect.minx > 12 || rect.maxx < 24 {
+		t.Fatalf("expected the undone rectangle to cover all applications but got %v", rect)
+	}
+	expectHeight(t, "undo", sculptor.heightmap.GetHeightAt(16, 16), 0)
+	expectHeight(t, "undo second position", sculptor.heightmap.GetHeightAt(20, 16), 0)
+	if _, ok := sculptor.Undo(); ok {
+		t.Fatalf("expected nothing to undo")
+	}
+
+	if _, ok := sculptor.Redo(); !ok {
+		t.Fatalf("expected redo to succeed")
+	}
+	expectHeight(t, "redo", sculptor.heightmap.GetHeightAt(16, 16), raised)
+	if _, ok := sculptor.Redo(); ok {
+		t.Fatalf("expected nothing to redo")
+	}
+}
+
+func TestSculptorRedoClearedByNewStroke(t *testing.T) {
+	sculptor := makeTestSculptor()
+	brush := MakeBrush(RAISE, 4, 1)
+
+	sculptor.Apply(brush, 8, 8, 4)
+	sculptor.EndStroke()
+	sculptor.Apply(brush, 24, 24, 4)
+	sculptor.EndStroke()
+	sculptor.Undo()
+	if len(sculptor.redo) != 1 {
+		t.Fatalf("expected 1 stroke to redo but got %v", len(sculptor.redo))
+	}
+
+	// a new stroke discards the reverted one
+	sculptor.Apply(brush, 16, 16, 4)
+	sculptor.EndStroke()
+	if _, ok := sculptor.Redo(); ok {
+		t.Fatalf("expected nothing to redo after a new stroke")
+	}
+	if len(sculptor.undo) != 2 {
+		t.Fatalf("expected 2 strokes but got %v", len(sculptor.undo))
+	}
+	expectHeight(t, "reverted stroke", sculptor.heightmap.GetHeightAt(24, 24), 0)
+}
+
+func TestSculptorUndoTrimming(t *testing.T) {
+	sculptor := makeTestSculptor()
+	brush := MakeBrush(RAISE, 2, 0.1)
+
+	for i := 0; i < sculptundocount+10; i++ {
+		sculptor.Apply(brush, 16, 16, 2)
+		sculptor.EndStroke()
+	}
+	if len(sculptor.undo) != sculptundocount {
+		t.Fatalf("expected %v strokes but got %v", sculptundocount, len(sculptor.undo))
+	}
+
+	// the oldest strokes are dropped thus undoing everything keeps their heights
+	for {
+		if _, ok := sculptor.Undo(); !ok {
+			break
+		}
+	}
+	expectHeight(t, "oldest strokes", sculptor.heightmap.GetHeightAt(16, 16), 1)
+}
+
+func TestSculptorFloatNotClamped(t *testing.T) {
+	sculptor := makeTestSculptor()
+
+	// a floating point Heightmap can be raised above its maximum height
+	sculptor.Apply(MakeBrush(RAISE, 4, 25), 16, 16, 4)
+	sculptor.EndStroke()
+	expectHeight(t, "above maximum height", sculptor.heightmap.GetHeightAt(16, 16), 25)
+
+	sculptor.Apply(MakeBrush(LOWER, 4, 50), 16, 16, 4)
+	sculptor.EndStroke()
+	expectHeight(t, "below zero", sculptor.heightmap.GetHeightAt(16, 16), -25)
+}
@@ -0,0 +1,133 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"math"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// SeamStats describes how visible the seams of a repeating Heightmap are.
+// The gradient jump of a pixel is the absolute difference between the slope to its left and the slope to its right neighbour,
+// which is the second difference of the height values. It is measured in normalized heights.
+// SeamMean and SeamMax are the mean and maximum gradient jump of all pixels on the seams where the image repeats,
+// while InteriorMean is the mean gradient jump of all other pixels.
+// A seam can't be seen if its mean gradient jump is close to the interior one.
+type SeamStats struct {
+	SeamMean     float32
+	SeamMax      float32
+	InteriorMean float32
+}
+
+// GetRatio returns the ratio between the mean gradient jump on the seams and in the interior.
+// A ratio close to 1 means the seam looks like any other part of the Heightmap.
+func (stats SeamStats) GetRatio() float32 {
+	if stats.InteriorMean == 0 {
+		if stats.SeamMean == 0 {
+			return 1
+		}
+		return float32(math.Inf(1))
+	}
+	return stats.SeamMean / stats.InteriorMean
+}
+
+// MakeSeamless removes the seams of the repeating Heightmap using offset-and-blend tiling.
+// Besides the image three copies are created that are offset by half the image size in x, in z and in both directions,
+// thus their seams lie in the middle of the image.
+// Within the border band of the specified width in pixels the image is cross-faded with the copies whose seams are far away,
+// such that every seam is hidden under a weight of 0.
+// The band is limited to a quarter of the image size and a band of 0 leaves the Heightmap unchanged.
+// Chunks that have been built before are not updated, thus this should happen before the Terrain is created.
+func (heightmap *Heightmap) MakeSeamless(band int32) {
+	heightmap.lock.Lock()
+	defer heightmap.lock.Unlock()
+
+	width := heightmap.data.GetWidth() - 1
+	height := heightmap.data.GetHeight() - 1
+	if band <= 0 || width < 4 || height < 4 {
+		return
+	}
+	bandx := float32(band)
+	if bandx > float32(width/4) {
+		bandx = float32(width / 4)
+	}
+	bandz := float32(band)
+	if bandz > float32(height/4) {
+		bandz = float32(height / 4)
+	}
+
+	// copy the repeating part of the image
+	values := make([]float32, width*height)
+	for z := int32(0); z < height; z++ {
+		for x := int32(0); x < width; x++ {
+			values[z*width+x] = heightmap.getHeightValue(x, z)
+		}
+	}
+	get := func(x, z int32) float32 {
+		return values[(z%height)*width+x%width]
+	}
+
+	// blend the image with its offset copies
+	hx, hz := width/2, height/2
+	for z := int32(0); z < height; z++ {
+		wz := calcSeamWeight(z, height, bandz)
+		for x := int32(0); x < width; x++ {
+			wx := calcSeamWeight(x, width, bandx)
+			val := wx*wz*get(x, z) +
+				(1-wx)*wz*get(x+hx, z) +
+				wx*(1-wz)*get(x, z+hz) +
+				(1-wx)*(1-wz)*get(x+hx, z+hz)
+			heightmap.setWrappedHeightValue(x, z, val)
+		}
+	}
+}
+
+// MeasureSeams returns the gradient jumps on the seams and in the interior of the repeating Heightmap.
+func (heightmap *Heightmap) MeasureSeams() SeamStats {
+	heightmap.lock.RLock()
+	defer heightmap.lock.RUnlock()
+
+	width := heightmap.data.GetWidth() - 1
+	height := heightmap.data.GetHeight() - 1
+	if width < 3 || height < 3 {
+		return SeamStats{}
+	}
+
+	var stats SeamStats
+	var seamsum, interiorsum float64
+	var seamcount, interiorcount int
+	for z := int32(0); z < height; z++ {
+		for x := int32(0); x < width; x++ {
+			h := heightmap.getWrappedHeightValue(x, z)
+			jumpx := mathutils.AbsF32(heightmap.getWrappedHeightValue(x+1, z) - 2*h + heightmap.getWrappedHeightValue(x-1, z))
+			jumpz := mathutils.AbsF32(heightmap.getWrappedHeightValue(x, z+1) - 2*h + heightmap.getWrappedHeightValue(x, z-1))
+
+			// the seam in x direction is at x = 0 and in z direction at z = 0
+			for i, jump := range [2]float32{jumpx, jumpz} {
+				if (i == 0 && x == 0) || (i == 1 && z == 0) {
+					seamsum += float64(jump)
+					seamcount++
+					stats.SeamMax = mathutils.MaxF32(stats.SeamMax, jump)
+				} else {
+					interiorsum += float64(jump)
+					interiorcount++
+				}
+			}
+		}
+	}
+	stats.SeamMean = float32(seamsum / float64(seamcount))
+	stats.InteriorMean = float32(interiorsum / float64(interiorcount))
+
+	return stats
+}
+
+// calcSeamWeight returns the weight of the unshifted image at pixel p of an image side of length size.
+// The seam lies between the pixels size-1 and 0, thus the weight is 0 for both of them and rises smoothly to 1 at the distance band.
+func calcSeamWeight(p, size int32, band float32) float32 {
+	dist := p
+	if size-1-p < dist {
+		dist = size - 1 - p
+	}
+	t := mathutils.MinF32(float32(dist)/band, 1)
+	return t * t * (3 - 2*t)
+}
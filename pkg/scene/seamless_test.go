@@ -0,0 +1,48 @@
+package scene
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/engine"
+)
+
+// makeRampHeightmap creates a Heightmap rising linearly in x and z, thus its repeating part has a seam at x = 0 and z = 0.
+// The last row and column are equal to the first ones like in a repeating image.
+func makeRampHeightmap(size int32) Heightmap {
+	data := engine.MakeEmptyRawFloatImageData(size+1, size+1)
+	for z := int32(0); z <= size; z++ {
+		for x := int32(0); x <= size; x++ {
+			data.SetValue(x, z, 0.5*float32(x%size)/float32(size)+0.25*float32(z%size)/float32(size))
+		}
+	}
+	return Heightmap{data: &data, maxheight: 100, filter: BILINEAR, lock: &sync.RWMutex{}}
+}
+
+func TestMakeSeamless(t *testing.T) {
+	heightmap := makeRampHeightmap(64)
+	before := heightmap.MeasureSeams()
+	if before.SeamMax < 0.1 {
+		t.Fatalf("expected a visible seam before blending but got %v", before.SeamMax)
+	}
+
+	heightmap.MakeSeamless(8)
+	after := heightmap.MeasureSeams()
+	if after.SeamMax >= before.SeamMax/4 {
+		t.Fatalf("expected the maximum seam jump to drop from %v but got %v", before.SeamMax, after.SeamMax)
+	}
+	if after.GetRatio() >= before.GetRatio() {
+		t.Fatalf("expected the seam ratio to drop from %v but got %v", before.GetRatio(), after.GetRatio())
+	}
+}
+
+func TestMakeSeamlessZeroBand(t *testing.T) {
+	heightmap := makeRampHeightmap(64)
+	before := heightmap.MeasureSeams()
+
+	// a band of 0 leaves the Heightmap unchanged
+	heightmap.MakeSeamless(0)
+	if after := heightmap.MeasureSeams(); after != before {
+		t.Fatalf("expected unchanged seams %v but got %v", before, after)
+	}
+}
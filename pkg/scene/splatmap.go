@@ -0,0 +1,125 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"math"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/engine"
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// Material is the kind of ground of a Tile.
+// It decides the color of the terrain as well as the kind of grass growing on it.
+type Material int
+
+const (
+	MEADOW Material = iota
+	DIRT
+	PATH
+	ROCK
+)
+
+// materialcount is the number of different Materials.
+const materialcount = 4
+
+// Splatmap holds the image data of a splat map.
+// The red channel encodes the Material where the range from 0 to 255 is split evenly between all Materials,
+// e.g. 0 is MEADOW, 85 is DIRT, 170 is PATH and 255 is ROCK.
+// The green channel is the grass density from 0 to 1.
+// The blue channel selects the species mix of the grass where the range from 0 to 255 is split evenly between all mixes,
+// e.g. 0 is the first mix and 255 is the last one, see Terrain.SetGrassMix.
+// It is sampled in block space the same way as the Heightmap and thus repeats every width-1 and height-1 pixels.
+type Splatmap struct {
+	data *engine.RawImageData
+}
+
+// MakeSplatmap creates a Splatmap from the image at the specified path.
+func MakeSplatmap(path string) (Splatmap, error) {
+	data, err := engine.MakeRawImageData(path)
+	if err != nil {
+		return Splatmap{}, err
+	}
+
+	return Splatmap{
+		data: &data,
+	}, nil
+}
+
+// GetBlockMaterial returns the Material and grass density at the position (x,z) in block space.
+// The Material is taken from the nearest pixel since Materials can't be interpolated.
+// The grass density is interpolated linearly between the 4 surrounding pixels.
+func (splatmap *Splatmap) GetBlockMaterial(x, z float32) (Material, float32) {
+	width := splatmap.data.GetWidth()
+	height := splatmap.data.GetHeight()
+	px := x * float32(width-1)
+	pz := z * float32(height-1)
+
+	// material of the nearest pixel
+	nx := wrapPixel(int32(mathutils.RoundF32(px)), width)
+	nz := wrapPixel(int32(mathutils.RoundF32(pz)), height)
+	r := float32(splatmap.data.GetR(nx, nz))
+	material := Material(mathutils.RoundF32(r / 255.0 * (materialcount - 1)))
+
+	// bilinear density
+	fx := float32(math.Floor(float64(px)))
+	fz := float32(math.Floor(float64(pz)))
+	ix := int32(fx)
+	iz := int32(fz)
+	d00 := splatmap.getDensity(ix, iz)
+	d10 := splatmap.getDensity(ix+1, iz)
+	d01 := splatmap.getDensity(ix, iz+1)
+	d11 := splatmap.getDensity(ix+1, iz+1)
+	d0 := mathutils.Interpolate(d00, d10, px-fx)
+	d1 := mathutils.Interpolate(d01, d11, px-fx)
+	density := mathutils.Interpolate(d0, d1, pz-fz)
+
+	return material, density
+}
+
+// GetBlockMix returns the index of the species mix at the position (x,z) in block space.
+// Like the Material it is taken from the nearest pixel.
+func (splatmap *Splatmap) GetBlockMix(x, z float32) int32 {
+	width := splatmap.data.GetWidth()
+	height := splatmap.data.GetHeight()
+	nx := wrapPixel(int32(mathutils.RoundF32(x*float32(width-1))), width)
+	nz := wrapPixel(int32(mathutils.RoundF32(z*float32(height-1))), height)
+	b := float32(splatmap.data.GetB(nx, nz))
+	return int32(mathutils.RoundF32(b / 255.0 * (grassmaxmixes - 1)))
+}
+
+// getDensity returns the grass density of the pixel (x,z) with the pixel position repeated in all directions.
+func (splatmap *Splatmap) getDensity(x, z int32) float32 {
+	x = wrapPixel(x, splatmap.data.GetWidth())
+	z = wrapPixel(z, splatmap.data.GetHeight())
+	return float32(splatmap.data.GetG(x, z)) / 255.0
+}
+
+// packMaterial stores the Material, the index of the species mix, the grass density and whether the Tile is submerged in a single float.
+// The integer part is the Material and the fractional part the density.
+// Submerged Tiles add materialcount to the integer part and the mix adds multiples of 2*materialcount.
+// A density of 1 is stored slightly below 1 to not change the Material.
+func packMaterial(material Material, mix int32, density float32, submerged bool) float32 {
+	density = float32(math.Max(0, math.Min(0.999, float64(density))))
+	if submerged {
+		material += materialcount
+	}
+	material += Material(mix * 2 * materialcount)
+	return float32(material) + density
+}
+
+// unpackMaterial is the inverse of packMaterial.
+func unpackMaterial(val float32) (Material, float32, bool) {
+	material := float32(math.Floor(float64(val)))
+	density := val - material
+	material = float32(math.Mod(float64(material), 2*materialcount))
+	submerged := material >= materialcount
+	if submerged {
+		material -= materialcount
+	}
+	return Material(material), density, submerged
+}
+
+// unpackMix returns the index of the species mix that has been stored by packMaterial.
+func unpackMix(val float32) int32 {
+	return int32(math.Floor(float64(val))) / (2 * materialcount)
+}
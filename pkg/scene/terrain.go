@@ -1,7 +1,10 @@
 package scene
 
 import (
+	"context"
 	"fmt"
+	"runtime"
+	"sort"
 
 	"github.com/go-gl/gl/v4.3-core/gl"
 	"github.com/go-gl/mathgl/mgl32"
@@ -11,24 +14,54 @@ import (
 	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
 )
 
+const (
+	// chunkqueuesize is the maximum number of Chunks that are waiting to be built.
+	chunkqueuesize = 256
+	// chunksperframe is the maximum number of built Chunks that are added to the Terrain per frame.
+	chunksperframe = 4
+	// chunkcachecount is the default maximum number of unloaded Chunks that are kept in the ChunkCache.
+	chunkcachecount = 512
+	// skirtdepth is the depth of the skirts that hide cracks between Chunks of different levels of detail relative to the Tile size.
+	skirtdepth = 2.0
+)
+
 // Terrain is an infinite terrain that updates itself depending on the position of the camera.
 // It consists of several Chunks that themselves consist of several Tiles.
-// Each frame Chunks out of the view distance of the camera are being destroyed while Chunks
-// that just got inside view distance are being created.
-// Each frame all Chunks that are either inside or intersect the view frustum are collected and rendered.
+// Each frame Chunks out of the unload distance of the camera are being moved into a ChunkCache while Chunks
+// that just got inside the load distance are either taken from the ChunkCache or requested from a ChunkLoader that builds them in the background.
+// The unload distance is bigger than the load distance such that Chunks at the border are not loaded and unloaded every frame.
+// With several levels of detail distant Chunks are replaced by coarser Chunks that cover the area of 4 finer Chunks each.
+// The Tile data of each loaded Chunk is uploaded once into a slot of a persistent buffer on the GPU.
+// Each frame the slots of all Chunks that are either inside or intersect the view frustum are collected and only these slot indices are uploaded.
 type Terrain struct {
 	// rendering
 	shader        engine.ShaderProgram
 	buffer        *engine.Mesh
-	terrainbuffer engine.SSBO
+	slots         *ChunkSlots
+	visiblebuffer engine.SSBO
+	visible       []int32
+	visiblechunks []Chunk
+	visibledists  []float32
 	grass         Grass
 	wind          Wind
+	trample       Trample
 	// factories
-	cf *ChunkFactory
-	tf *TileFactory
+	cf       *ChunkFactory
+	tf       *TileFactory
+	loader   *ChunkLoader
+	cache    *ChunkCache
+	sculptor *Sculptor
+	// bounds
+	wrapmode        WrapMode
+	blocksize       float32
+	blockresolution int32
 	// chunk
 	chunks    map[string]Chunk
+	stale     map[string]bool
 	chunksize float32
+	// level of detail
+	lodlevels int32
+	selection lodSelection
 	// tile
 	tilesize      float32
 	tilesperchunk int32
@@ -38,48 +71,100 @@ type Terrain struct {
 	unloaddist float32
 }
 
+// TerrainSettings are the optional parameters of a Terrain.
+// The Splatmap provides the Material and grass density of each Tile, it can be nil.
+// The Rules thin out the grass of each Tile depending on its slope, altitude and position, they can be nil.
+// The Species are the kinds of grass growing on the terrain, without any species the default species is used.
+// Tiles with a corner below the SeaLevel are submerged and get no grass, a SeaLevel of 0 disables the water.
+// LodLevels specifies the number of levels of detail, each level doubles the size of its Tiles and the view distance.
+// A value of 1 disables the level of detail.
+// TrampleRegrowth is the amount of flattening of trampled grass that is removed per second, see Trample.
+// The Seed decides the root positions of the grass blades, the idle movement of the Wind and the pattern of the NOISE brush.
+type TerrainSettings struct {
+	Splatmap        *Splatmap
+	Rules           *GrassRules
+	Species         []GrassSpecies
+	SeaLevel        float32
+	LodLevels       int32
+	TrampleRegrowth float32
+	Seed            int64
+}
+
+// MakeTerrainSettings returns TerrainSettings with the specified seed
+// and without splat map, rules, water and level of detail using the default species.
+func MakeTerrainSettings(seed int64) TerrainSettings {
+	return TerrainSettings{
+		Splatmap:        nil,
+		Rules:           nil,
+		Species:         nil,
+		SeaLevel:        0.0,
+		LodLevels:       1,
+		TrampleRegrowth: 0.05,
+		Seed:            seed,
+	}
+}
+
 // MakeTerrain constructs a Terrain entity.
+// The source provides the heights of the terrain, e.g. a Heightmap or a NoiseHeightmap.
+// The wrapmode specifies how the source is continued outside of the block.
+// With FINITE the terrain only consists of a single block starting at the origin.
 // Blocksize specifies the size of the height-map.
 // Thus a big value for blocksize stretches the height-map.
 // The blockresolution specifies the number of Chunks in x and z direction.
 // While the chunkresolution specifies the number of Tiles in a Chunk in x and z direction.
 // The terrainheight is the maximum height of the terrain.
+// It is raised to the maximum height of the source if the source provides one, like a HeightmapAtlas with a bigger height scale.
 // Bladecount specifies the number of grass blades per Tile.
-// The grassheight is the maximum height of the grass.
-// Viewdist is used the specify when to delete and create Chunks.
+// The grassheight is the maximum height of the grass, it is raised to the tallest species.
+// Viewdist is used the specify when to create Chunks, Chunks are unloaded once they are one Chunk further away.
 // The windradius specifies the radius of the Wind grid.
 // Windinfluence specifes the compression of the bell curve used for the Wind acceleration relative to the windradius.
 // A bigger value for the windinfluence mean that the bell curve is more compressed.
-func MakeTerrain(shaderpath, texpath string, blocksize float32, blockresolution, chunkresolution int32, terrainheight float32, bladecount int, grassheight, viewdist float32, windradius int32, windinfluence float32) (Terrain, error) {
+// All optional parameters are specified by the settings, see TerrainSettings.
+func MakeTerrain(shaderpath, texpath string, source HeightSource, wrapmode WrapMode, blocksize float32, blockresolution, chunkresolution int32, terrainheight float32, bladecount int, grassheight, viewdist float32, windradius int32, windinfluence float32, settings TerrainSettings) (Terrain, error) {
+	splatmap := settings.Splatmap
+	rules := settings.Rules
+	species := settings.Species
+	sealevel := settings.SeaLevel
+	lodlevels := settings.LodLevels
+	trampleregrowth := settings.TrampleRegrowth
+	seed := settings.Seed
+
 	// setup shaderprogram
 	shader, err := engine.MakeGeomProgram(shaderpath+"/terrain/terrain.vert", shaderpath+"/terrain/terrain.geom", shaderpath+"/terrain/terrain.frag")
 	if err != nil {
 		return Terrain{}, err
 	}
 
-	// get heightmap data
-	heightmap, err := MakeHeightmap(texpath+"heightmap.png", terrainheight)
+	// setup vertex buffer with a single point that is instanced once per tile
+	pointbuffer, err := engine.MakeSimpleMesh([]float32{0.0, 0.0, 0.0}, 3, gl.POINTS, gl.STATIC_DRAW)
 	if err != nil {
 		return Terrain{}, err
 	}
+	shader.AddRenderable(&pointbuffer)
 
-	// setup vertex buffer
-	positionsbuffer, err := engine.MakeSimpleMesh(nil, 3, gl.POINTS, gl.STREAM_DRAW)
-	if err != nil {
-		return Terrain{}, err
+	// the bounding boxes of the chunks have to contain the tallest grass
+	grassheight = mathutils.MaxF32(grassheight, getMaxSpeciesHeight(species))
+	// and the highest point of the source, otherwise they are culled too early
+	if bounded, ok := source.(BoundedHeightSource); ok {
+		terrainheight = mathutils.MaxF32(terrainheight, bounded.GetMaxHeight())
 	}
-	shader.AddRenderable(&positionsbuffer)
-	// setup ssbo
-	tilebytesize := 12 * 4 // vec4 + vec4 + vec2 + float + float
-	terrainbuffer := engine.MakeEmptySSBO(tilebytesize)
 
 	// setup factories
 	chunksize := blocksize / float32(blockresolution)
 	tilesize := chunksize / float32(chunkresolution)
+	tilesperchunk := chunkresolution * chunkresolution
+	loaddist := viewdist + chunksize
+	unloaddist := viewdist + 2*chunksize
+	cuts := MakeGrassCuts(tilesize)
 	tf := TileFactory{
 		tilesize:      tilesize,
 		tilesperblock: blockresolution * chunkresolution,
-		heightmap:     &heightmap,
+		wrapmode:      wrapmode,
+		sealevel:      sealevel,
+		source:        source,
+		splatmap:      splatmap,
+		rules:         rules,
 	}
 	cf := ChunkFactory{
 		chunksize:       chunksize,
@@ -87,16 +172,43 @@ func MakeTerrain(shaderpath, texpath string, blocksize float32, blockresolution,
 		terrainheight:   terrainheight,
 		chunkresolution: chunkresolution,
 		tf:              &tf,
+		cuts:            &cuts,
+	}
+
+	if lodlevels < 1 {
+		lodlevels = 1
+	}
+
+	// setup chunk cache
+	cache := MakeChunkCache(chunkcachecount, 0)
+
+	// setup ssbos for the tile data of all loaded chunks and the slots of the visible chunks
+	tilebytesize := 12 * 4 // vec4 + vec4 + vec2 + float + float
+	slotcount := calcSlotCount(unloaddist, chunksize)
+	slots := MakeChunkSlots(tilebytesize, tilesperchunk, slotcount)
+	visiblebuffer := engine.MakeSSBO(4, int(slotcount))
+
+	// setup sculpting if the heights come from a heightmap
+	var sculptor *Sculptor
+	if heightmap, ok := source.(*Heightmap); ok {
+		s := MakeSculptor(heightmap, seed)
+		sculptor = &s
 	}
 
 	// setup grass
-	grass, err := MakeGrass(shaderpath, texpath, bladecount, grassheight, viewdist, windradius)
+	grass, err := MakeGrass(shaderpath, texpath, bladecount, grassheight, viewdist, windradius, seed, species, &cuts)
 	if err != nil {
 		return Terrain{}, err
 	}
 
 	// setup wind
-	wind, err := MakeWind(shaderpath, int(windradius), windinfluence, tilesize)
+	wind, err := MakeWind(shaderpath, int(windradius), windinfluence, tilesize, seed)
+	if err != nil {
+		return Terrain{}, err
+	}
+
+	// setup trample map with 4 cells per tile
+	trample, err := MakeTrample(shaderpath, trampleradius, tilesize/4, trampleregrowth)
 	if err != nil {
 		return Terrain{}, err
 	}
@@ -105,60 +217,87 @@ func MakeTerrain(shaderpath, texpath string, blocksize float32, blockresolution,
 	return Terrain{
 		// rendering
 		shader:        shader,
-		buffer:        &positionsbuffer,
-		terrainbuffer: terrainbuffer,
+		buffer:        &pointbuffer,
+		slots:         &slots,
+		visiblebuffer: visiblebuffer,
+		visible:       nil,
+		visiblechunks: nil,
+		visibledists:  nil,
 		grass:         grass,
 		wind:          wind,
+		trample:       trample,
 		// factories
-		cf: &cf,
-		tf: &tf,
+		cf:       &cf,
+		tf:       &tf,
+		loader:   NewChunkLoader(context.Background(), &cf, runtime.NumCPU(), chunkqueuesize, chunksperframe),
+		cache:    &cache,
+		sculptor: sculptor,
+		// bounds
+		wrapmode:        wrapmode,
+		blocksize:       blocksize,
+		blockresolution: blockresolution,
 		// chunk
 		chunks:    map[string]Chunk{},
+		stale:     map[string]bool{},
 		chunksize: chunksize,
+		// level of detail
+		lodlevels: lodlevels,
 		// tile
 		tilesize:      tilesize,
-		tilesperchunk: chunkresolution * chunkresolution,
+		tilesperchunk: tilesperchunk,
 		tilecount:     0,
 		// loading
-		loaddist:   viewdist + chunksize,
-		unloaddist: viewdist + chunksize,
+		loaddist:   loaddist,
+		unloaddist: unloaddist,
 	}, nil
 }
 
 // Update delete and creates new Chunks depending on the distance to the camera.
 // In addition a view frustum culling is performed to only use the Chunks that are inside the view frustum or intersecting it.
-func (terrain *Terrain) Update(pos, cameradelta mgl32.Vec3, mvp mgl32.Mat4) {
+// The dt is the time in seconds that passed since the last frame, it lets the trampled grass regrow.
+func (terrain *Terrain) Update(pos, cameradelta mgl32.Vec3, mvp mgl32.Mat4, dt float32) {
 	// update wind
 	terrain.wind.Update(pos, cameradelta)
 
+	// update trample map
+	terrain.trample.Update(pos, selectColliders(terrain.getTramplingColliders(), pos), dt)
+
 	// update chunks
+	terrain.selection = terrain.selectChunks(pos)
+	terrain.integrate()
+	terrain.load()
 	terrain.unload(pos)
-	terrain.load(pos)
 
-	// collect terrain data
-	poss := []float32{}
-	data := []float32{}
-	var tilecount int32 = 0
+	// collect slots of visible chunks
+	terrain.visible = terrain.visible[:0]
+	terrain.visiblechunks = terrain.visiblechunks[:0]
+	terrain.visibledists = terrain.visibledists[:0]
 	for _, chunk := range terrain.chunks {
+		if terrain.isHidden(chunk) {
+			continue
+		}
 		if collision.CheckAABBFrustum(chunk.aabb, mvp) != collision.OUTSIDE {
-			poss = append(poss, chunk.poss...)
-			data = append(data, chunk.data...)
-			tilecount += terrain.tilesperchunk
+			terrain.visible = append(terrain.visible, chunk.slot)
+			terrain.visiblechunks = append(terrain.visiblechunks, chunk)
+			terrain.visibledists = append(terrain.visibledists, distxz(chunk.pos, pos))
 		}
 	}
-	terrain.tilecount = tilecount
+
+	// closest chunks first, if the grass doesn't fit into the blade buffer the farthest chunks are dropped
+	sort.Sort(visibleByDistance{terrain.visible, terrain.visiblechunks, terrain.visibledists})
+	visiblecount := len(terrain.visible)
+	terrain.tilecount = int32(visiblecount) * terrain.tilesperchunk
 
 	// early return to prevent error
-	if tilecount == 0 {
+	if visiblecount == 0 {
 		return
 	}
 
-	// update terrain tile positions
-	terrain.buffer.GetVAO().GetVertexBuffer(0).UpdateData(poss)
-
-	// update terrain data
-	terrain.terrainbuffer.Resize(int(tilecount))
-	terrain.terrainbuffer.UploadArray(data)
+	// update visible slots
+	if visiblecount > terrain.visiblebuffer.Len() {
+		terrain.visiblebuffer.Resize(2 * visiblecount)
+	}
+	terrain.visiblebuffer.UploadIntArrayInRange(terrain.visible, 0, visiblecount)
 }
 
 // Render draws the Chunks that had been collected in the Update method.
@@ -166,8 +305,11 @@ func (terrain *Terrain) Render(M, V, P mgl32.Mat4, camerapos mgl32.Vec3) {
 	lightdir := mgl32.Vec3{2.0, 2.0, 0.0}
 	lightcolor := mgl32.Vec3{0.0, 1.0, 0.0}
 
-	terrain.terrainbuffer.Bind(0)
+	terrain.slots.Bind(0)
 	terrain.wind.velocityfield.Bind(1)
+	terrain.visiblebuffer.Bind(2)
+	terrain.trample.getField().Bind(6)
+	terrain.slots.BindHeights(7)
 
 	// render terrain
 	terrain.shader.Use()
@@ -175,110 +317,245 @@ func (terrain *Terrain) Render(M, V, P mgl32.Mat4, camerapos mgl32.Vec3) {
 	terrain.shader.UpdateMat4("V", V)
 	terrain.shader.UpdateMat4("P", P)
 	terrain.shader.UpdateFloat32("tilesize", terrain.tilesize)
+	terrain.shader.UpdateInt32("tilesPerChunk", terrain.tilesperchunk)
+	terrain.shader.UpdateInt32("chunkResolution", terrain.cf.chunkresolution)
+	terrain.shader.UpdateFloat32("skirtDepth", terrain.getSkirtDepth())
 	terrain.shader.UpdateVec3("cameraPos", camerapos)
 	terrain.shader.UpdateVec3("lightDir", lightdir)
 	terrain.shader.UpdateVec3("lightColor", lightcolor)
 	terrain.shader.UpdateFloat32("ambientIntensity", 0.4)
 	terrain.shader.UpdateFloat32("diffuseIntensity", 0.4)
-	terrain.shader.UpdateFloat32("d1", (terrain.unloaddist-200)/8)
-	terrain.shader.UpdateFloat32("d2", terrain.unloaddist-200)
-	terrain.shader.Render()
+	terrain.shader.UpdateFloat32("d1", (terrain.loaddist-200)/8)
+	terrain.shader.UpdateFloat32("d2", terrain.loaddist-200)
+	terrain.shader.RenderInstanced(terrain.tilecount)
 
 	// render grass
-	terrain.grass.Render(int32(terrain.tilecount), terrain.tilesize, M, V, P, camerapos)
+	terrain.grass.Render(terrain.tilecount, terrain.tilesize, terrain.tilesperchunk, &terrain.trample, M, V, P, camerapos)
 
-	terrain.terrainbuffer.Unbind()
+	terrain.slots.Unbind()
 	terrain.wind.velocityfield.Unbind()
+	terrain.visiblebuffer.Unbind()
+	terrain.trample.getField().Unbind()
+	terrain.slots.UnbindHeights()
+}
+
+// SetGrassMix specifies how often each GrassSpecies grows on Tiles that use the species mix with the index mix.
+// Each Tile picks one of the 8 mixes with the blue channel of the Splatmap, without a Splatmap all Tiles use the mix 0.
+// The weights are relative to each other and are specified in the order of the GrassSpecies.
+func (terrain *Terrain) SetGrassMix(mix int32, weights ...float32) error {
+	return terrain.grass.SetSpeciesMix(mix, weights...)
+}
+
+// AddCollider adds a Collider that pushes the grass away until ClearColliders is called.
+// Moving Colliders are usually cleared and added again each frame.
+// At most 256 Colliders are kept, adding more replaces the oldest ones.
+func (terrain *Terrain) AddCollider(collider Collider) {
+	terrain.grass.AddCollider(collider)
+}
+
+// ClearColliders removes all Colliders from the grass.
+// Grass that has been trampled by the Colliders stays flattened until it has regrown.
+func (terrain *Terrain) ClearColliders() {
+	terrain.grass.ClearColliders()
+}
+
+// SetTrampleRegrowth specifies the amount of flattening of trampled grass that is removed per second.
+// A value of 0 keeps the grass flattened until it leaves the trample map.
+func (terrain *Terrain) SetTrampleRegrowth(regrowth float32) {
+	terrain.trample.SetRegrowth(regrowth)
+}
+
+// SetGrassPipeline switches between generating the grass blades in a geometry shader
+// and culling them in a compute shader before drawing them indirectly.
+// An error is returned if the GPU doesn't support the compute shader, then the grass stays in the geometry shader.
+func (terrain *Terrain) SetGrassPipeline(pipeline GrassPipeline) error {
+	return terrain.grass.SetPipeline(pipeline)
+}
+
+// GetGrassPipeline returns the GrassPipeline that is used for rendering the grass.
+func (terrain *Terrain) GetGrassPipeline() GrassPipeline {
+	return terrain.grass.GetPipeline()
+}
+
+// getTramplingColliders returns the Colliders that touch the grass.
+// Colliders high above the ground, like the one of a flying camera, don't trample the grass.
+func (terrain *Terrain) getTramplingColliders() []Collider {
+	var colliders []Collider
+	for _, collider := range terrain.grass.colliders {
+		lowest := collider.start
+		if collider.end.Y() < lowest.Y() {
+			lowest = collider.end
+		}
+		if lowest.Y()-collider.radius <= terrain.GetHeight(lowest)+terrain.grass.height {
+			colliders = append(colliders, collider)
+		}
+	}
+	return colliders
+}
+
+// GetVisibleChunks returns the Chunks that had been collected in the Update method.
+// The returned slice is reused by the next call of Update.
+func (terrain *Terrain) GetVisibleChunks() []Chunk {
+	return terrain.visiblechunks
 }
 
 // GetHeight returns the height of the terrain at the specified position pos.
+// Use GetSurface to also get the normal and slope or to handle invalid positions.
+// For invalid positions a height of 0 is returned.
 func (terrain *Terrain) GetHeight(pos mgl32.Vec3) float32 {
-	x, z := terrain.getChunkPos(pos.X(), pos.Z())
+	sample, err := terrain.GetSurface(pos.X(), pos.Z())
+	if err != nil {
+		return 0.0
+	}
+	return sample.Height
+}
+
+// IsInBounds returns true if the position (x,z) is part of the terrain.
+// Only a FINITE terrain has bounds, all other terrains are infinite.
+func (terrain *Terrain) IsInBounds(x, z float32) bool {
+	if terrain.wrapmode != FINITE {
+		return true
+	}
+	return x >= 0 && x < terrain.blocksize && z >= 0 && z < terrain.blocksize
+}
+
+// SetLoadDistances changes the distances to the camera at which Chunks are loaded and unloaded.
+// The unloaddist is raised to the loaddist if it is smaller.
+// The difference between both distances prevents Chunks at the border from being loaded and unloaded every frame.
+func (terrain *Terrain) SetLoadDistances(loaddist, unloaddist float32) {
+	terrain.loaddist = loaddist
+	terrain.unloaddist = mathutils.MaxF32(loaddist, unloaddist)
+	terrain.slots.Reserve(calcSlotCount(terrain.unloaddist, terrain.chunksize))
+}
+
+// SetCacheLimits changes the maximum number of Chunks and the maximum number of bytes of the ChunkCache.
+// A limit of 0 means that the respective limit is not checked.
+func (terrain *Terrain) SetCacheLimits(maxcount, maxbytes int) {
+	terrain.cache.SetLimits(maxcount, maxbytes)
+}
+
+// GetCacheStats returns the hit and miss counters of the ChunkCache.
+func (terrain *Terrain) GetCacheStats() ChunkCacheStats {
+	return terrain.cache.GetStats()
+}
+
+// Close stops building Chunks in the background.
+func (terrain *Terrain) Close() {
+	terrain.loader.Close()
+}
 
-	chunkresolution := int32(mathutils.RoundF32(terrain.chunksize / terrain.tilesize))
+// Delete stops building Chunks in the background and frees all shaders, textures and GPU buffers of the Terrain,
+// including those of its Grass, Wind and Trample map.
+func (terrain *Terrain) Delete() {
+	terrain.Close()
+	terrain.grass.Delete()
+	terrain.wind.Delete()
+	terrain.trample.Delete()
+	terrain.slots.Delete()
+	terrain.visiblebuffer.Delete()
+	terrain.buffer.Delete()
+	terrain.shader.Delete()
+}
 
-	var height float32 = 0.0
-	if chunk, ok := terrain.chunks[makeKey(x, z)]; ok {
-		// get position within chunk
-		dx := pos.X() - float32(x)*terrain.chunksize
-		dz := pos.Z() - float32(z)*terrain.chunksize
-		if dx < 0 {
-			dx = terrain.chunksize + dx
+// load adds the selected Chunks that are not loaded yet.
+// Missing Chunks are taken from the ChunkCache if possible.
+// Otherwise they are requested if they have not been requested yet.
+// Chunks closer to the camera are requested first.
+func (terrain *Terrain) load() {
+	// rebuild loaded chunks whose heights have changed, they keep their old data until the rebuilt chunk arrives
+	for key := range terrain.stale {
+		chunk, ok := terrain.chunks[key]
+		if !ok {
+			delete(terrain.stale, key)
+			continue
 		}
-		if dz < 0 {
-			dz = terrain.chunksize + dz
+		// a discarded chunk has to be collected before it can be requested again
+		if terrain.loader.IsPending(chunk.lod, chunk.x, chunk.z) {
+			continue
 		}
-		tx := int32(dx / terrain.tilesize)
-		tz := int32(dz / terrain.tilesize)
-		tidx := tz*chunkresolution + tx
-
-		tileposx := chunk.data[tidx*12+8]
-		tileposz := chunk.data[tidx*12+9]
-		if mathutils.AbsF32(tileposx-pos.X()) > terrain.tilesize/2 ||
-			mathutils.AbsF32(tileposz-pos.Z()) > terrain.tilesize {
-			fmt.Printf("Wrong tile (%v,%v)  (%v,%v : %v,%v)\n", pos.X(), pos.Z(), x, z, tx, tz)
-			panic("Err")
+		if !terrain.loader.Request(chunk.lod, chunk.x, chunk.z) {
+			return
 		}
+		delete(terrain.stale, key)
+	}
 
-		if tidx >= 0 && tidx < terrain.tilesperchunk {
-			rx := dx - float32(tx)*terrain.tilesize
-			rz := dz - float32(tz)*terrain.tilesize
-			if rx < rz {
-				a := chunk.data[tidx*12]
-				b := chunk.data[tidx*12+1]
-				c := chunk.data[tidx*12+2]
-				d := chunk.data[tidx*12+3]
-				height = -(d + a*pos.X() + c*pos.Z()) / b
-			} else {
-				a := chunk.data[tidx*12+4]
-				b := chunk.data[tidx*12+5]
-				c := chunk.data[tidx*12+6]
-				d := chunk.data[tidx*12+7]
-				height = -(d + a*pos.X() + c*pos.Z()) / b
-			}
+	// collect all selected chunks that are not in the chunks map
+	var missing []chunkRequest
+	var dists []float32
+	for i, request := range terrain.selection.leaves {
+		// is chunk not yet present?
+		key := makeLODKey(request.lod, request.x, request.z)
+		if _, ok := terrain.chunks[key]; ok || terrain.loader.IsPending(request.lod, request.x, request.z) {
+			continue
+		}
+		// reuse previously unloaded chunk
+		if chunk, ok := terrain.cache.Get(request.lod, request.x, request.z); ok {
+			terrain.addChunk(chunk)
+			continue
 		}
+		missing = append(missing, request)
+		dists = append(dists, terrain.selection.dists[i])
 	}
-	return height
-}
-
-// load creates new Chunks that are within the viewing distance to the camera at position pos.
-// New Chunks are only created if they are not present yet.
-func (terrain *Terrain) load(pos mgl32.Vec3) {
-	centerx, centerz := terrain.getChunkPos(pos.X(), pos.Z())
-
-	chunkrad := int32(mathutils.RoundF32(terrain.loaddist / terrain.chunksize))
-
-	// check if chunk position it not in chunks map and create a new one
-	for z := -chunkrad; z <= chunkrad; z++ {
-		for x := -chunkrad; x <= chunkrad; x++ {
-			cx := centerx + x
-			cz := centerz + z
-			// is chunk not yet present?
-			if _, ok := terrain.chunks[makeKey(cx, cz)]; !ok {
-				// is chunk in load radius?
-				chunkpos := mgl32.Vec3{
-					float32(cx)*terrain.chunksize + terrain.chunksize/2,
-					0.0,
-					float32(cz)*terrain.chunksize + terrain.chunksize/2,
-				}
-				if distxz(pos, chunkpos) < terrain.loaddist {
-					// create a new chunk
-					terrain.chunks[makeKey(cx, cz)] = terrain.cf.MakeChunk(cx, cz)
-				}
-			}
+
+	// request the closest chunks first
+	sort.Sort(byDistance{missing, dists})
+	for _, request := range missing {
+		if !terrain.loader.Request(request.lod, request.x, request.z) {
+			// queue is full, try again next frame
+			break
+		}
+		// count the miss once per request instead of once per lookup
+		terrain.cache.RecordMiss()
+	}
+}
+
+// integrate adds the Chunks that have been built in the background.
+// Rebuilt Chunks that are still loaded replace the data in the slot of the old Chunk.
+// Chunks that are not selected anymore are moved into the ChunkCache.
+func (terrain *Terrain) integrate() {
+	for _, chunk := range terrain.loader.Collect() {
+		if old, ok := terrain.chunks[chunk.key()]; ok {
+			chunk.slot = old.slot
+			terrain.slots.Upload(chunk.slot, &chunk)
+			terrain.chunks[chunk.key()] = chunk
+		} else if terrain.selection.keys[chunk.key()] {
+			terrain.addChunk(chunk)
+		} else {
+			terrain.cache.Put(chunk)
 		}
 	}
 }
 
-// unload destroys Chunks that are outside the view distance of the camera at position pos.
+// unload moves Chunks that are not selected anymore into the ChunkCache.
+// To prevent holes a Chunk is only unloaded once the Chunks replacing it are loaded
+// or it is outside the unload distance of the camera at position pos.
 func (terrain *Terrain) unload(pos mgl32.Vec3) {
 	for key, chunk := range terrain.chunks {
-		if distxz(chunk.pos, pos) > terrain.unloaddist {
-			delete(terrain.chunks, key)
+		if !terrain.selection.keys[key] && terrain.isReplaced(chunk, pos) {
+			terrain.removeChunk(key, chunk)
 		}
 	}
 }
 
+// addChunk uploads the Chunk into a free slot and adds it to the loaded Chunks.
+func (terrain *Terrain) addChunk(chunk Chunk) {
+	chunk.slot = terrain.slots.Alloc(&chunk)
+	terrain.chunks[chunk.key()] = chunk
+}
+
+// removeChunk releases the slot of the Chunk and moves it from the loaded Chunks into the ChunkCache.
+// Chunks that wait for being rebuilt are dropped instead since their data is outdated.
+func (terrain *Terrain) removeChunk(key string, chunk Chunk) {
+	terrain.slots.Free(chunk.slot)
+	chunk.slot = -1
+	delete(terrain.chunks, key)
+	if terrain.stale[key] {
+		delete(terrain.stale, key)
+		return
+	}
+	terrain.cache.Put(chunk)
+}
+
 // getChunkPos returns the coordinate of a Chunk at position (x,z).
 func (terrain *Terrain) getChunkPos(x, z float32) (int32, int32) {
 	cx := int32(x / terrain.chunksize)
@@ -292,14 +569,66 @@ func (terrain *Terrain) getChunkPos(x, z float32) (int32, int32) {
 	return cx, cz
 }
 
+// getSkirtDepth returns the depth of the skirts at the Chunk borders relative to the Tile size.
+// Skirts are only needed if Chunks of different levels of detail are next to each other.
+func (terrain *Terrain) getSkirtDepth() float32 {
+	if terrain.lodlevels <= 1 {
+		return 0.0
+	}
+	return skirtdepth
+}
+
+// calcSlotCount returns the maximum number of Chunks whose centers can be within the unloaddist around the camera.
+func calcSlotCount(unloaddist, chunksize float32) int32 {
+	side := 2*int32(mathutils.CeilF32(unloaddist/chunksize)) + 1
+	return side * side
+}
+
 // makeKey used the (x,z) coordinate of a Chunk as its unique key.
 func makeKey(x, z int32) string {
 	return fmt.Sprint(x, "-", z)
 }
 
+// makeLODKey uses the level of detail lod and the (x,z) coordinate of a Chunk as its unique key.
+// Chunks of level 0 have the same key as returned by makeKey.
+func makeLODKey(lod, x, z int32) string {
+	if lod == 0 {
+		return makeKey(x, z)
+	}
+	return fmt.Sprint(lod, ":", x, "-", z)
+}
+
 // distxz returns the 2D euclidean distance in the x-z plane between posa and posb.
 func distxz(posa, posb mgl32.Vec3) float32 {
 	dx := mathutils.AbsF32(posa.X() - posb.X())
 	dz := mathutils.AbsF32(posa.Z() - posb.Z())
 	return mathutils.SqrtF32(dx*dx + dz*dz)
 }
+
+// byDistance sorts chunk requests by their distance to the camera.
+type byDistance struct {
+	requests []chunkRequest
+	dists    []float32
+}
+
+func (b byDistance) Len() int           { return len(b.requests) }
+func (b byDistance) Less(i, j int) bool { return b.dists[i] < b.dists[j] }
+func (b byDistance) Swap(i, j int) {
+	b.requests[i], b.requests[j] = b.requests[j], b.requests[i]
+	b.dists[i], b.dists[j] = b.dists[j], b.dists[i]
+}
+
+// visibleByDistance sorts the slots of the visible chunks by the distance of the chunks to the camera.
+type visibleByDistance struct {
+	slots  []int32
+	chunks []Chunk
+	dists  []float32
+}
+
+func (b visibleByDistance) Len() int           { return len(b.slots) }
+func (b visibleByDistance) Less(i, j int) bool { return b.dists[i] < b.dists[j] }
+func (b visibleByDistance) Swap(i, j int) {
+	b.slots[i], b.slots[j] = b.slots[j], b.slots[i]
+	b.chunks[i], b.chunks[j] = b.chunks[j], b.chunks[i]
+	b.dists[i], b.dists[j] = b.dists[j], b.dists[i]
+}
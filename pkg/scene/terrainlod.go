@@ -0,0 +1,180 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// results of checking a Chunk against the bounds of a FINITE terrain.
+const (
+	chunkoutside = iota
+	chunkintersect
+	chunkinside
+)
+
+// lodSelection is the set of Chunks that should be loaded for the current camera position.
+// The Chunks are the leaves of a quadtree per Chunk of the coarsest level of detail.
+// Leaves contains the requested Chunks with their distances to the camera while inner contains the keys of all split Chunks.
+type lodSelection struct {
+	leaves []chunkRequest
+	dists  []float32
+	keys   map[string]bool
+	inner  map[string]bool
+}
+
+// selectChunks builds the quadtree of all Chunks around the camera at position pos.
+// The roots are the Chunks of the coarsest level that are within the load distance scaled by their size.
+// A Chunk of level lod is split into 4 Chunks of level lod-1 as long as it is closer than loaddist*2^(lod-1).
+// Chunks that intersect the bounds of a FINITE terrain are always split, those outside of the bounds are dropped.
+// With a single level of detail this selects all Chunks within the load distance.
+func (terrain *Terrain) selectChunks(pos mgl32.Vec3) lodSelection {
+	selection := lodSelection{
+		keys:  map[string]bool{},
+		inner: map[string]bool{},
+	}
+
+	// find all roots within the load distance
+	root := terrain.lodlevels - 1
+	rootsize := terrain.getLODChunkSize(root)
+	rootdist := terrain.loaddist * float32(int32(1)<<uint(root))
+	centerx := int32(mathutils.FloorF32(pos.X() / rootsize))
+	centerz := int32(mathutils.FloorF32(pos.Z() / rootsize))
+	rad := int32(mathutils.CeilF32(rootdist / rootsize))
+	for z := -rad; z <= rad; z++ {
+		for x := -rad; x <= rad; x++ {
+			cx := centerx + x
+			cz := centerz + z
+			if distxz(pos, makeCenteredVec(cx, cz, 0.0, rootsize)) < rootdist {
+				terrain.selectNode(&selection, root, cx, cz, pos)
+			}
+		}
+	}
+
+	return selection
+}
+
+// selectNode adds the Chunk of level lod at (x,z) or its children to the selection.
+func (terrain *Terrain) selectNode(selection *lodSelection, lod, x, z int32, pos mgl32.Vec3) {
+	bounds := terrain.checkChunkBounds(lod, x, z)
+	if bounds == chunkoutside {
+		return
+	}
+
+	key := makeLODKey(lod, x, z)
+	dist := distxz(pos, makeCenteredVec(x, z, 0.0, terrain.getLODChunkSize(lod)))
+	split := lod > 0 && (bounds == chunkintersect || dist < terrain.loaddist*float32(int32(1)<<uint(lod-1)))
+	if !split {
+		selection.leaves = append(selection.leaves, chunkRequest{lod, x, z})
+		selection.dists = append(selection.dists, dist)
+		selection.keys[key] = true
+		return
+	}
+
+	selection.inner[key] = true
+	terrain.selectNode(selection, lod-1, 2*x, 2*z, pos)
+	terrain.selectNode(selection, lod-1, 2*x+1, 2*z, pos)
+	terrain.selectNode(selection, lod-1, 2*x, 2*z+1, pos)
+	terrain.selectNode(selection, lod-1, 2*x+1, 2*z+1, pos)
+}
+
+// isReplaced returns true if a loaded Chunk that is not part of the selection anymore can be unloaded.
+// This is the case if the Chunks that cover the same area in the selection are all loaded.
+// Chunks that do not overlap any selected Chunk are replaced once they are outside the unload distance of the camera at position pos.
+func (terrain *Terrain) isReplaced(chunk Chunk, pos mgl32.Vec3) bool {
+	// a coarser chunk has been selected
+	for lod := chunk.lod + 1; lod < terrain.lodlevels; lod++ {
+		shift := uint(lod - chunk.lod)
+		key := makeLODKey(lod, chunk.x>>shift, chunk.z>>shift)
+		if terrain.selection.keys[key] {
+			_, ok := terrain.chunks[key]
+			return ok
+		}
+	}
+
+	// finer chunks have been selected
+	if terrain.selection.inner[chunk.key()] {
+		return terrain.isCovered(chunk.lod, chunk.x, chunk.z)
+	}
+
+	// chunk is outside of the selection
+	unloaddist := terrain.unloaddist * float32(int32(1)<<uint(terrain.lodlevels-1))
+	return distxz(chunk.pos, pos) > unloaddist
+}
+
+// isCovered returns true if all selected Chunks within the area of the Chunk of level lod at (x,z) are loaded.
+func (terrain *Terrain) isCovered(lod, x, z int32) bool {
+	key := makeLODKey(lod, x, z)
+	if terrain.selection.keys[key] {
+		_, ok := terrain.chunks[key]
+		return ok
+	}
+	if !terrain.selection.inner[key] || lod == 0 {
+		return false
+	}
+	return terrain.isCovered(lod-1, 2*x, 2*z) &&
+		terrain.isCovered(lod-1, 2*x+1, 2*z) &&
+		terrain.isCovered(lod-1, 2*x, 2*z+1) &&
+		terrain.isCovered(lod-1, 2*x+1, 2*z+1)
+}
+
+// isHidden returns true if a coarser Chunk covering the selected Chunk is still loaded.
+// In this case the Chunk is not rendered until the coarser Chunk is replaced to prevent overlapping Chunks.
+func (terrain *Terrain) isHidden(chunk Chunk) bool {
+	if !terrain.selection.keys[chunk.key()] {
+		return false
+	}
+	for lod := chunk.lod + 1; lod < terrain.lodlevels; lod++ {
+		shift := uint(lod - chunk.lod)
+		key := makeLODKey(lod, chunk.x>>shift, chunk.z>>shift)
+		if _, ok := terrain.chunks[key]; ok && !terrain.selection.keys[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// checkChunkBounds checks whether the Chunk of level lod at (x,z) is inside, outside or intersecting the bounds of a FINITE terrain.
+// For all other terrains Chunks are always inside.
+func (terrain *Terrain) checkChunkBounds(lod, x, z int32) int {
+	if terrain.wrapmode != FINITE {
+		return chunkinside
+	}
+
+	// range of level 0 chunks covered by the chunk
+	span := int32(1) << uint(lod)
+	minx, maxx := x*span, (x+1)*span
+	minz, maxz := z*span, (z+1)*span
+	res := terrain.blockresolution
+	if maxx <= 0 || maxz <= 0 || minx >= res || minz >= res {
+		return chunkoutside
+	}
+	if minx < 0 || minz < 0 || maxx > res || maxz > res {
+		return chunkintersect
+	}
+	return chunkinside
+}
+
+// findChunk returns the loaded Chunk that contains the Tile at the global coordinate (gx,gz) of level 0.
+// Chunks of finer levels are preferred.
+// The returned coordinate is the Tile coordinate within the Chunk in the grid of its level.
+func (terrain *Terrain) findChunk(gx, gz int32) (Chunk, int32, int32, bool) {
+	res := terrain.cf.chunkresolution
+	for lod := int32(0); lod < terrain.lodlevels; lod++ {
+		// tile coordinate in the grid of this level
+		tx := gx >> uint(lod)
+		tz := gz >> uint(lod)
+		cx := floorDiv(tx, res)
+		cz := floorDiv(tz, res)
+		if chunk, ok := terrain.chunks[makeLODKey(lod, cx, cz)]; ok {
+			return chunk, tx - cx*res, tz - cz*res, true
+		}
+	}
+	return Chunk{}, 0, 0, false
+}
+
+// getLODChunkSize returns the side length of a Chunk of level lod.
+func (terrain *Terrain) getLODChunkSize(lod int32) float32 {
+	return terrain.chunksize * float32(int32(1)<<uint(lod))
+}
@@ -0,0 +1,141 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+// SurfaceSample describes the surface of the Terrain at one position in the x-z plane.
+// Normal is the normal of the triangle at that position and points upwards.
+// Slope is the angle between the Normal and the y-axis in radians.
+type SurfaceSample struct {
+	Height float32
+	Normal mgl32.Vec3
+	Slope  float32
+}
+
+// GetSurface returns the height, normal and slope of the Terrain at the position (x,z).
+// If a loaded Chunk covers the position its Tile data is used, which is the Tile of the level of detail that is rendered.
+// Otherwise the Tile is created by the TileFactory, thus the position can be anywhere on the Terrain.
+// An error is returned for positions that are not finite, outside of the bounds of a FINITE terrain or if the Tile is degenerate.
+func (terrain *Terrain) GetSurface(x, z float32) (SurfaceSample, error) {
+	if !isFinite(x) || !isFinite(z) {
+		return SurfaceSample{}, fmt.Errorf("Invalid terrain position (%v,%v)", x, z)
+	}
+	if !terrain.IsInBounds(x, z) {
+		return SurfaceSample{}, fmt.Errorf("Terrain position (%v,%v) is out of bounds", x, z)
+	}
+
+	// find the triangle containing the position
+	plane, err := terrain.getSurfacePlane(x, z)
+	if err != nil {
+		return SurfaceSample{}, err
+	}
+
+	return calcSurfaceSample(plane, x, z)
+}
+
+// GetSurfaces returns the SurfaceSamples of all positions in the x-z plane.
+// Positions that cannot be queried get an empty SurfaceSample,
+// in that case the returned error describes the first failed position.
+func (terrain *Terrain) GetSurfaces(positions []mgl32.Vec2) ([]SurfaceSample, error) {
+	samples := make([]SurfaceSample, len(positions))
+	var firsterr error
+	for i, pos := range positions {
+		sample, err := terrain.GetSurface(pos.X(), pos.Y())
+		if err != nil {
+			if firsterr == nil {
+				firsterr = fmt.Errorf("Position %v: %v", i, err)
+			}
+			continue
+		}
+		samples[i] = sample
+	}
+	return samples, firsterr
+}
+
+// GetNormal returns the normal of the Terrain at the position (x,z).
+func (terrain *Terrain) GetNormal(x, z float32) (mgl32.Vec3, error) {
+	sample, err := terrain.GetSurface(x, z)
+	return sample.Normal, err
+}
+
+// GetSlope returns the angle between the Terrain normal at the position (x,z) and the y-axis in radians.
+func (terrain *Terrain) GetSlope(x, z float32) (float32, error) {
+	sample, err := terrain.GetSurface(x, z)
+	return sample.Slope, err
+}
+
+// getSurfacePlane returns the plane equation of the triangle containing the position (x,z).
+// The Tile is looked up in the loaded Chunks like in Raycast, thus coarser Chunks of the level of detail are used where they are rendered.
+// If no Chunk covers the position the Tile of level 0 is created by the TileFactory.
+func (terrain *Terrain) getSurfacePlane(x, z float32) (mgl32.Vec4, error) {
+	// global Tile coordinate of level 0
+	gx := int32(mathutils.FloorF32(x / terrain.tilesize))
+	gz := int32(mathutils.FloorF32(z / terrain.tilesize))
+
+	var (
+		tri1, tri2 mgl32.Vec4
+		minx, minz float32
+		tilesize   = terrain.tilesize
+	)
+	if chunk, tx, tz, ok := terrain.findChunk(gx, gz); ok {
+		res := terrain.cf.chunkresolution
+		tidx := tz*res + tx
+		if int(tidx*12+12) > len(chunk.data) {
+			return mgl32.Vec4{}, fmt.Errorf("Tile (%v,%v) is missing in chunk (%v,%v) of level %v", tx, tz, chunk.x, chunk.z, chunk.lod)
+		}
+		tri1, tri2 = chunk.getTilePlanes(tidx)
+
+		// corner of the Tile in the grid of its level
+		tilesize = terrain.tilesize * float32(int32(1)<<uint(chunk.lod))
+		minx = float32(chunk.x*res+tx) * tilesize
+		minz = float32(chunk.z*res+tz) * tilesize
+	} else {
+		d := terrain.tf.MakeTile(gx, gz).data
+		tri1 = mgl32.Vec4{d[0], d[1], d[2], d[3]}
+		tri2 = mgl32.Vec4{d[4], d[5], d[6], d[7]}
+		minx = float32(gx) * tilesize
+		minz = float32(gz) * tilesize
+	}
+
+	// the upper left triangle covers rx < rz and the lower right one rx >= rz
+	if x-minx < z-minz {
+		return tri1, nil
+	}
+	return tri2, nil
+}
+
+// calcSurfaceSample solves the plane equation Ax+By+Cz+D=0 for y at the position (x,z) and
+// derives the normal and slope from the plane.
+func calcSurfaceSample(plane mgl32.Vec4, x, z float32) (SurfaceSample, error) {
+	normal := plane.Vec3()
+	if mathutils.AbsF32(normal.Y()) < 1e-6 {
+		return SurfaceSample{}, fmt.Errorf("Vertical terrain triangle at (%v,%v)", x, z)
+	}
+
+	// make sure the normal points upwards
+	if normal.Y() < 0 {
+		normal = normal.Mul(-1)
+	}
+	normal = normal.Normalize()
+
+	height := -(plane.W() + plane.X()*x + plane.Z()*z) / plane.Y()
+	slope := float32(math.Acos(math.Min(1, float64(normal.Y()))))
+
+	return SurfaceSample{
+		Height: height,
+		Normal: normal,
+		Slope:  slope,
+	}, nil
+}
+
+// isFinite returns false if val is NaN or infinite.
+func isFinite(val float32) bool {
+	return !math.IsNaN(float64(val)) && !math.IsInf(float64(val), 0)
+}
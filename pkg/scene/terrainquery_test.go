@@ -0,0 +1,126 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// planeSource is a HeightSource describing the plane y = base + dx*x + dz*z in block space.
+type planeSource struct {
+	base, dx, dz float32
+}
+
+func (source *planeSource) GetBlockHeight(x, z float32) float32 {
+	return source.base + source.dx*x + source.dz*z
+}
+
+func (source *planeSource) IsRepeating() bool {
+	return false
+}
+
+// makeQueryTerrain creates a Terrain without any GPU resources with Tiles of size 2 and 8x8 Tiles per Chunk.
+// The heights are taken from the source and a block spans 128 units.
+func makeQueryTerrain(source HeightSource, wrapmode WrapMode) *Terrain {
+	tf := TileFactory{tilesize: 2, tilesperblock: 65, wrapmode: wrapmode, source: source}
+	cf := ChunkFactory{chunksize: 16, chunkheight: 110, terrainheight: 100, chunkresolution: 8, tf: &tf}
+	return &Terrain{
+		cf:            &cf,
+		tf:            &tf,
+		wrapmode:      wrapmode,
+		blocksize:     128,
+		chunks:        map[string]Chunk{},
+		chunksize:     16,
+		lodlevels:     1,
+		tilesize:      2,
+		tilesperchunk: 64,
+	}
+}
+
+// expectSurface checks the height and the normal of a SurfaceSample against the plane y = base + dx*x + dz*z in world space.
+func expectSurface(t *testing.T, sample SurfaceSample, x, z, base, dx, dz float32) {
+	t.Helper()
+	height := base + dx*x + dz*z
+	if mgl32.Abs(sample.Height-height) > 1e-3 {
+		t.Fatalf("expected height %v at (%v,%v) but got %v", height, x, z, sample.Height)
+	}
+	normal := mgl32.Vec3{-dx, 1, -dz}.Normalize()
+	if sample.Normal.Sub(normal).Len() > 1e-4 {
+		t.Fatalf("expected normal %v at (%v,%v) but got %v", normal, x, z, sample.Normal)
+	}
+	slope := float32(math.Acos(float64(normal.Y())))
+	if mgl32.Abs(sample.Slope-slope) > 1e-4 {
+		t.Fatalf("expected slope %v at (%v,%v) but got %v", slope, x, z, sample.Slope)
+	}
+}
+
+func TestGetSurfaceLoadedChunk(t *testing.T) {
+	terrain := makeQueryTerrain(&planeSource{10, 64, 32}, REPEAT)
+
+	// the loaded Chunk is built from another plane, thus its data has to be preferred over the TileFactory
+	loaded := makeQueryTerrain(&planeSource{20, -32, 0}, REPEAT)
+	terrain.chunks[makeKey(0, 0)] = loaded.cf.MakeChunk(0, 0)
+
+	for _, pos := range []mgl32.Vec2{{1.3, 0.7}, {0.2, 1.9}, {15.9, 15.9}, {7, 3}} {
+		sample, err := terrain.GetSurface(pos.X(), pos.Y())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectSurface(t, sample, pos.X(), pos.Y(), 20, -0.25, 0)
+	}
+}
+
+func TestGetSurfaceFallbackTile(t *testing.T) {
+	terrain := makeQueryTerrain(&planeSource{10, 64, 32}, REPEAT)
+	terrain.chunks[makeKey(0, 0)] = terrain.cf.MakeChunk(0, 0)
+
+	// positions outside of the loaded Chunk are created by the TileFactory
+	for _, pos := range []mgl32.Vec2{{-5.1, 9.9}, {200, 300}, {-1000.5, 33}, {16.1, 0}} {
+		sample, err := terrain.GetSurface(pos.X(), pos.Y())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectSurface(t, sample, pos.X(), pos.Y(), 10, 0.5, 0.25)
+	}
+}
+
+func TestGetSurfaceInvalidPositions(t *testing.T) {
+	terrain := makeQueryTerrain(&planeSource{10, 64, 32}, FINITE)
+	nan := float32(math.NaN())
+	inf := float32(math.Inf(1))
+
+	for _, pos := range []mgl32.Vec2{{nan, 0}, {0, nan}, {inf, 0}, {0, -inf}, {-1, 5}, {5, 128}, {200, 200}} {
+		if _, err := terrain.GetSurface(pos.X(), pos.Y()); err == nil {
+			t.Fatalf("expected an error for position %v", pos)
+		}
+	}
+	if _, err := terrain.GetSurface(5, 127); err != nil {
+		t.Fatalf("expected position within the bounds to be valid: %v", err)
+	}
+}
+
+func TestGetSurfacesErrors(t *testing.T) {
+	terrain := makeQueryTerrain(&planeSource{10, 64, 32}, FINITE)
+	positions := []mgl32.Vec2{{1, 2}, {float32(math.NaN()), 3}, {4, 5}, {-10, 5}}
+
+	samples, err := terrain.GetSurfaces(positions)
+	if err == nil {
+		t.Fatal("expected an error for the invalid positions")
+	}
+	if len(samples) != len(positions) {
+		t.Fatalf("expected %v samples but got %v", len(positions), len(samples))
+	}
+
+	// valid positions are still sampled while invalid ones stay empty
+	expectSurface(t, samples[0], 1, 2, 10, 0.5, 0.25)
+	expectSurface(t, samples[2], 4, 5, 10, 0.5, 0.25)
+	if samples[1] != (SurfaceSample{}) || samples[3] != (SurfaceSample{}) {
+		t.Fatalf("expected empty samples for invalid positions but got %v and %v", samples[1], samples[3])
+	}
+
+	// the error describes the first failed position
+	if _, first := terrain.GetSurface(positions[1].X(), positions[1].Y()); err.Error() != "Position 1: "+first.Error() {
+		t.Fatalf("expected the error of position 1 but got: %v", err)
+	}
+}
@@ -2,18 +2,59 @@
 package scene
 
 import (
+	"math"
+
 	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
 	"github.com/go-gl/mathgl/mgl32"
 )
 
+// HeightSource provides the height of the terrain to the TileFactory.
+// Positions are specified in block space where one block spans from 0 to 1 in x and z direction.
+// A repeating HeightSource only describes a single block and is thus only asked for positions within one block.
+// All other HeightSources are asked for positions anywhere in the world.
+type HeightSource interface {
+	GetBlockHeight(x, z float32) float32
+	IsRepeating() bool
+}
+
+// BoundedHeightSource is a HeightSource that knows the maximum height it can return.
+// The Terrain uses it to make the bounding boxes of its Chunks tall enough.
+type BoundedHeightSource interface {
+	HeightSource
+	GetMaxHeight() float32
+}
+
+// WrapMode specifies how Tile coordinates outside of the block are mapped onto a repeating HeightSource.
+// REPEAT repeats the block in all directions.
+// MIRRORED_REPEAT repeats the block but mirrors every other repetition.
+// CLAMP_TO_EDGE extends the border of the block to infinity.
+// FINITE limits the terrain to a single block and samples it like CLAMP_TO_EDGE.
+// HeightSources that are not repeating are defined everywhere and thus only the bounds of FINITE apply to them.
+type WrapMode int
+
+const (
+	REPEAT WrapMode = iota
+	MIRRORED_REPEAT
+	CLAMP_TO_EDGE
+	FINITE
+)
+
 // TileFactory is creating single Tiles.
 // The TileFactory knows of the size of a tile and how many tiles are in one block.
-// Additionally it has a reference to a height-map used to grab the height of the four points in a Tile.
+// Additionally it has a reference to a HeightSource used to grab the height of the four points in a Tile.
+// The wrapmode decides how positions outside of the block are mapped into the block.
+// The optional Splatmap provides the Material and grass density of each Tile.
+// The optional GrassRules reduce the grass density depending on the slope, altitude and position of a Tile.
+// Tiles with a corner below the sealevel are submerged and get no grass.
 type TileFactory struct {
 	tilesize      float32
 	tilesperblock int32
+	wrapmode      WrapMode
+	sealevel      float32
 
-	heightmap *Heightmap
+	source   HeightSource
+	splatmap *Splatmap
+	rules    *GrassRules
 }
 
 // Tile contains its position and the plane data of the two triangles that make up a Tile.
@@ -33,24 +74,37 @@ type Tile struct {
 // A Tile consists of 2 triangles with 6 vertices in total.
 // The heights of the vertices are calculated from a height-map.
 // The Tile coordinates go from left to right for x and bottom to top for z.
-//    x------->
-//  ^ 1-------3
-//  | |     / |
-//  | |   /   |
-//  | | /     |
-//  z 2-------4
+//
+//	  x------->
+//	^ 1-------3
+//	| |     / |
+//	| |   /   |
+//	| | /     |
+//	z 2-------4
 func (tf *TileFactory) MakeTile(tx, tz int32) Tile {
+	return tf.MakeLODTile(0, tx, tz)
+}
+
+// MakeLODTile constructs a Tile at position (tx,tz) of the level of detail lod.
+// A Tile of level lod spans 2^lod Tiles of level 0 in x and z direction, thus (tx,tz) is a coordinate in the grid of that level.
+// The level is stored in the Tile data such that the shaders can derive the size of the Tile.
+func (tf *TileFactory) MakeLODTile(lod, tx, tz int32) Tile {
+	// corner coordinates in the grid of level 0
+	step := int32(1) << uint(lod)
+	x0, x1 := tx*step, (tx+1)*step
+	z0, z1 := tz*step, (tz+1)*step
+
 	// get the height values for the height-map
-	h1 := tf.getHeight(tx, tz+1)
-	h2 := tf.getHeight(tx, tz)
-	h3 := tf.getHeight(tx+1, tz+1)
-	h4 := tf.getHeight(tx+1, tz)
+	h1 := tf.getHeight(x0, z1)
+	h2 := tf.getHeight(x0, z0)
+	h3 := tf.getHeight(x1, z1)
+	h4 := tf.getHeight(x1, z0)
 
 	// calculate positions of all 4 points
-	p1 := tf.calcPlanePos(tx, tz+1, h1)
-	p2 := tf.calcPlanePos(tx, tz, h2)
-	p3 := tf.calcPlanePos(tx+1, tz+1, h3)
-	p4 := tf.calcPlanePos(tx+1, tz, h4)
+	p1 := tf.calcPlanePos(x0, z1, h1)
+	p2 := tf.calcPlanePos(x0, z0, h2)
+	p3 := tf.calcPlanePos(x1, z1, h3)
+	p4 := tf.calcPlanePos(x1, z0, h4)
 
 	// solve the plane formula for both triangles
 	tri1 := calcPlane(p1, p2, p3)
@@ -63,30 +117,134 @@ func (tf *TileFactory) MakeTile(tx, tz int32) Tile {
 		(p1.Z() + p2.Z()) / 2,
 	}
 
+	// get the material at the tile center
+	material, density := tf.getMaterial(float32(x0+x1)/2, float32(z0+z1)/2)
+	mix := tf.getMix(float32(x0+x1)/2, float32(z0+z1)/2)
+
+	// no grass is growing under water
+	lowest := mathutils.MinF32(mathutils.MinF32(h1, h2), mathutils.MinF32(h3, h4))
+	submerged := lowest < tf.sealevel
+	if submerged {
+		density = 0.0
+	}
+
 	// construct the Tile from the position and plane data
-	return Tile{
+	tile := Tile{
 		pos: []float32{pos.X(), 0.0, pos.Z()}, // position of the Tile's center
 		data: []float32{
 			tri1.X(), tri1.Y(), tri1.Z(), tri1.W(), // triangle 1
 			tri2.X(), tri2.Y(), tri2.Z(), tri2.W(), // triangle 2
 			pos.X(), pos.Z(), // tile position
-			3.0, // level of detail
-			0.0, // padding to have 12 byte
+			float32(lod), // level of detail
+			packMaterial(material, mix, density, submerged), // material, species mix, grass density and submerged flag
 		},
 	}
+
+	// thin out the grass depending on the shape of the Tile
+	if tf.rules != nil {
+		density *= tf.rules.EvaluateTile(tile)
+		tile.data[11] = packMaterial(material, mix, density, submerged)
+	}
+
+	return tile
+}
+
+// getMaterial grabs the Material and grass density from the Splatmap at the continuous position (x,z).
+// The position is mapped into the block depending on the WrapMode.
+// Without a Splatmap every Tile is a MEADOW with full grass density.
+func (tf *TileFactory) getMaterial(x, z float32) (Material, float32) {
+	if tf.splatmap == nil {
+		return MEADOW, 1.0
+	}
+	last := float32(tf.tilesperblock - 1)
+	bx := tf.wrapTileCoordF(x) / last
+	bz := tf.wrapTileCoordF(z) / last
+	return tf.splatmap.GetBlockMaterial(bx, bz)
+}
+
+// getMix grabs the index of the species mix from the Splatmap at the continuous position (x,z).
+// Without a Splatmap every Tile uses the first mix.
+func (tf *TileFactory) getMix(x, z float32) int32 {
+	if tf.splatmap == nil {
+		return 0
+	}
+	last := float32(tf.tilesperblock - 1)
+	bx := tf.wrapTileCoordF(x) / last
+	bz := tf.wrapTileCoordF(z) / last
+	return tf.splatmap.GetBlockMix(bx, bz)
 }
 
-// getHeight grabs the height from the height-map at position (x,z).
-// This position gets normalized and repeated in all directions to have a continuous repeating heightmap.
+// getHeight grabs the height from the HeightSource at position (x,z).
+// For a repeating HeightSource this position gets mapped into the block depending on the WrapMode.
 func (tf *TileFactory) getHeight(x, z int32) float32 {
 	// calc xz-coordinate relative to the block size
-	rx := tf.calcTileBounds(x)
-	rz := tf.calcTileBounds(z)
-	// map tile coordinate to image pixel position
-	ix := mathutils.MapI32(rx, 0, tf.tilesperblock-1, 0, tf.heightmap.GetWidth()-1)
-	iz := mathutils.MapI32(rz, 0, tf.tilesperblock-1, 0, tf.heightmap.GetHeight()-1)
-	// read height at pixel position (ix,iz)
-	return tf.heightmap.GetHeightAt(ix, iz)
+	if tf.source.IsRepeating() {
+		x = tf.wrapTileCoord(x)
+		z = tf.wrapTileCoord(z)
+	}
+	// map tile coordinate to block space
+	bx := mathutils.MapF32(float32(x), 0, float32(tf.tilesperblock-1), 0, 1)
+	bz := mathutils.MapF32(float32(z), 0, float32(tf.tilesperblock-1), 0, 1)
+	// read height at block position (bx,bz)
+	return tf.source.GetBlockHeight(bx, bz)
+}
+
+// wrapTileCoord maps the Tile coordinate x into the block depending on the WrapMode.
+func (tf *TileFactory) wrapTileCoord(x int32) int32 {
+	switch tf.wrapmode {
+	case MIRRORED_REPEAT:
+		return tf.calcMirroredTileBounds(x)
+	case CLAMP_TO_EDGE, FINITE:
+		return tf.calcClampedTileBounds(x)
+	default:
+		return tf.calcTileBounds(x)
+	}
+}
+
+// wrapTileCoordF maps the continuous Tile coordinate x into the block depending on the WrapMode.
+func (tf *TileFactory) wrapTileCoordF(x float32) float32 {
+	last := float32(tf.tilesperblock - 1)
+	switch tf.wrapmode {
+	case MIRRORED_REPEAT:
+		rx := float32(math.Mod(float64(x), float64(2*last)))
+		if rx < 0 {
+			rx += 2 * last
+		}
+		if rx > last {
+			rx = 2*last - rx
+		}
+		return rx
+	case CLAMP_TO_EDGE, FINITE:
+		return mgl32.Clamp(x, 0, last)
+	default:
+		rx := float32(math.Mod(float64(x), float64(tf.tilesperblock)))
+		if rx < 0 {
+			rx += float32(tf.tilesperblock)
+		}
+		return rx
+	}
+}
+
+// samplesPixels returns true if the Chunk of level lod at (cx,cz) reads heights from the pixels within rect.
+// Width and height are the number of pixels after which the image repeats.
+// Since rect is axis aligned it is enough to check the x and z coordinates of the Tile corners separately.
+func (tf *TileFactory) samplesPixels(lod, cx, cz, chunkresolution int32, rect pixelRect, width, height float32) bool {
+	last := float32(tf.tilesperblock - 1)
+	step := int32(1) << uint(lod)
+	x0 := cx * chunkresolution * step
+	z0 := cz * chunkresolution * step
+
+	hitx, hitz := false, false
+	var i int32
+	for i = 0; i <= chunkresolution && !hitx; i++ {
+		px := float32(tf.wrapTileCoord(x0+i*step)) / last * width
+		hitx = isInWrappedRange(px, rect.minx, rect.maxx, width)
+	}
+	for i = 0; i <= chunkresolution && !hitz; i++ {
+		pz := float32(tf.wrapTileCoord(z0+i*step)) / last * height
+		hitz = isInWrappedRange(pz, rect.minz, rect.maxz, height)
+	}
+	return hitx && hitz
 }
 
 // calcTileBounds repeats a position p to be relative to the block size.
@@ -99,6 +257,34 @@ func (tf *TileFactory) calcTileBounds(x int32) int32 {
 	return rx
 }
 
+// calcMirroredTileBounds repeats a position p to be relative to the block size while mirroring every other block.
+// The returned value is between 0 and the side length of a block.
+func (tf *TileFactory) calcMirroredTileBounds(x int32) int32 {
+	last := tf.tilesperblock - 1
+	if last <= 0 {
+		return 0
+	}
+	rx := x % (2 * last)
+	if rx < 0 {
+		rx = 2*last + rx
+	}
+	if rx > last {
+		rx = 2*last - rx
+	}
+	return rx
+}
+
+// calcClampedTileBounds clamps a position p to be between 0 and the side length of a block.
+func (tf *TileFactory) calcClampedTileBounds(x int32) int32 {
+	if x < 0 {
+		return 0
+	}
+	if x > tf.tilesperblock-1 {
+		return tf.tilesperblock - 1
+	}
+	return x
+}
+
 // clacPlanePos returns the position of the Tile center as vec3.
 // The y component is specified by height.
 func (tf *TileFactory) calcPlanePos(x, z int32, height float32) mgl32.Vec3 {
@@ -109,6 +295,15 @@ func (tf *TileFactory) calcPlanePos(x, z int32, height float32) mgl32.Vec3 {
 	}
 }
 
+// isInWrappedRange returns true if p or one of its repetitions every period is between min and max.
+func isInWrappedRange(p, min, max, period float32) bool {
+	if period <= 0 {
+		return p >= min && p <= max
+	}
+	q := p + mathutils.CeilF32((min-p)/period)*period
+	return q <= max
+}
+
 // calcPlane calculates the components A,B,C,D for the plane equation Ax+By+Cz+D=0.
 // The points v1,v2,v3 have to be specified with v2 being the center point and the points have to be defined counter-clockwise.
 func calcPlane(v1, v2, v3 mgl32.Vec3) mgl32.Vec4 {
@@ -0,0 +1,149 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"github.com/go-gl/gl/v4.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/engine"
+	"github.com/adrianderstroff/realtime-grass/pkg/mathutils"
+)
+
+const (
+	// trampleradius is the radius of the Trample grid of the Terrain in cells.
+	trampleradius = 64
+	// tramplemaxstep is the maximum time step in seconds of one update, such that the grass doesn't regrow at once after a hiccup.
+	tramplemaxstep = 0.1
+)
+
+// Trample is a world-aligned grid that stores how much the grass is flattened.
+// The grid is centered around the camera and scrolls with it like the Wind grid, cells leaving the grid are forgotten.
+// Colliders stamp into the grid and the trampled grass regrows at the regrowth rate per second,
+// thus a regrowth rate of 0.05 lets flattened grass stand up again within 20 seconds.
+// The grid is double buffered, a compute pass reads the previous state and writes the new one.
+type Trample struct {
+	shader         *engine.ShaderProgram
+	fields         [2]*engine.SSBO
+	current        int
+	colliderbuffer *engine.SSBO
+	groupcount     uint32
+	griddimension  int32
+	fieldsize      int32
+	cellsize       float32
+	regrowth       float32
+	centerx        int32
+	centerz        int32
+}
+
+// MakeTrample constructs the Trample grid.
+// The side of the grid is 2*radius+1 cells and each cell is cellsize wide.
+// The regrowth is the amount of flattening that is removed per second, where 1 is fully flattened grass.
+func MakeTrample(shaderpath string, radius int, cellsize, regrowth float32) (Trample, error) {
+	griddim := 2*radius + 1
+	fieldsize := griddim * griddim
+
+	// create both fields with one float per cell
+	var fields [2]*engine.SSBO
+	for i := range fields {
+		field := engine.MakeSSBO(4, fieldsize)
+		field.UploadValue([]float32{0})
+		fields[i] = &field
+	}
+
+	// create collider buffer
+	colliderbuffer := engine.MakeSSBO(collidersize*4, grassmaxcolliders)
+
+	// create trample compute shader
+	shader, err := engine.MakeComputeProgram(shaderpath + "trample/trample.comp")
+	if err != nil {
+		return Trample{}, err
+	}
+
+	// calculate number of work groups necessary
+	groupcount := uint32(mathutils.CeilF32(float32(fieldsize) / 16.0))
+
+	return Trample{
+		shader:         &shader,
+		fields:         fields,
+		current:        0,
+		colliderbuffer: &colliderbuffer,
+		groupcount:     groupcount,
+		griddimension:  int32(griddim),
+		fieldsize:      int32(fieldsize),
+		cellsize:       cellsize,
+		regrowth:       regrowth,
+		centerx:        0,
+		centerz:        0,
+	}, nil
+}
+
+// SetRegrowth changes the amount of flattening that is removed per second.
+func (trample *Trample) SetRegrowth(regrowth float32) {
+	trample.regrowth = mathutils.MaxF32(regrowth, 0)
+}
+
+// Update scrolls the grid to the camera position pos, lets the grass regrow and stamps the colliders into the grid.
+// The colliders are packed as returned by selectColliders.
+// Only the position in the x-z plane is taken into account, thus colliders flatten the grass below them regardless of their height.
+// The dt is the time in seconds since the last update, it is limited to tramplemaxstep.
+func (trample *Trample) Update(pos mgl32.Vec3, colliders []float32, dt float32) {
+	dt = mgl32.Clamp(dt, 0, tramplemaxstep)
+
+	// get cell in which the camera is in
+	centerx := int32(mathutils.FloorF32(pos.X() / trample.cellsize))
+	centerz := int32(mathutils.FloorF32(pos.Z() / trample.cellsize))
+	dx := centerx - trample.centerx
+	dz := centerz - trample.centerz
+
+	// upload colliders
+	collidercount := len(colliders) / collidersize
+	if collidercount > 0 {
+		trample.colliderbuffer.UploadArrayInRange(colliders, 0, collidercount)
+	}
+
+	// bind buffers, the current field is read and the other one is written
+	source := trample.fields[trample.current]
+	destination := trample.fields[1-trample.current]
+	source.Bind(0)
+	destination.Bind(1)
+	trample.colliderbuffer.Bind(2)
+
+	// update trample map
+	trample.shader.Use()
+	trample.shader.UpdateInt32("size", trample.fieldsize)
+	trample.shader.UpdateInt32("dim", trample.griddimension)
+	trample.shader.UpdateInt32("dx", dx)
+	trample.shader.UpdateInt32("dz", dz)
+	trample.shader.UpdateInt32("centerX", centerx)
+	trample.shader.UpdateInt32("centerZ", centerz)
+	trample.shader.UpdateInt32("colliderCount", int32(collidercount))
+	trample.shader.UpdateFloat32("cellsize", trample.cellsize)
+	trample.shader.UpdateFloat32("decay", trample.regrowth*dt)
+	trample.shader.Compute(trample.groupcount, 1, 1)
+
+	// make the new field visible to the grass shader
+	gl.MemoryBarrier(gl.SHADER_STORAGE_BARRIER_BIT)
+
+	// unbind buffers
+	source.Unbind()
+	destination.Unbind()
+	trample.colliderbuffer.Unbind()
+
+	// swap fields and save the new center
+	trample.current = 1 - trample.current
+	trample.centerx = centerx
+	trample.centerz = centerz
+}
+
+// getField returns the field holding the current state of the grid.
+func (trample *Trample) getField() *engine.SSBO {
+	return trample.fields[trample.current]
+}
+
+// Delete destroys the buffers and the compute shader of the Trample grid.
+func (trample *Trample) Delete() {
+	trample.shader.Delete()
+	trample.fields[0].Delete()
+	trample.fields[1].Delete()
+	trample.colliderbuffer.Delete()
+}
@@ -0,0 +1,132 @@
+// Package scene contains all main entities for rendering and/or interaction with the user.
+package scene
+
+import (
+	"github.com/go-gl/gl/v4.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/adrianderstroff/realtime-grass/pkg/engine"
+)
+
+// Water renders an animated water plane at the sea level above all submerged Chunks.
+// The water plane is only drawn for the visible Chunks collected by the Terrain that contain at least one submerged Tile.
+// It reflects the Terrain in screen space and falls back to the skybox if the reflected ray leaves the screen.
+// The color of the water depends on the depth of the water taken from the depth texture of the FBO the scene has been rendered into.
+type Water struct {
+	shader      engine.ShaderProgram
+	buffer      *engine.Mesh
+	chunkbuffer engine.SSBO
+	chunks      []float32
+	chunkcount  int32
+	scenefbo    *engine.FBO
+	sealevel    float32
+	width       int32
+	height      int32
+	time        float32
+}
+
+// MakeWater constructs the Water entity at the height sealevel.
+// The width and height have to match the size of the FBO the scene is rendered into.
+func MakeWater(shaderpath string, sealevel float32, width, height int32) (Water, error) {
+	// setup shaderprogram
+	shader, err := engine.MakeGeomProgram(shaderpath+"/water/water.vert", shaderpath+"/water/water.geom", shaderpath+"/water/water.frag")
+	if err != nil {
+		return Water{}, err
+	}
+
+	// setup vertex buffer with a single point that is instanced once per chunk
+	pointbuffer, err := engine.MakeSimpleMesh([]float32{0.0, 0.0, 0.0}, 3, gl.POINTS, gl.STATIC_DRAW)
+	if err != nil {
+		return Water{}, err
+	}
+	shader.AddRenderable(&pointbuffer)
+
+	// setup ssbo with position and size of all submerged chunks
+	chunkbuffer := engine.MakeSSBO(4*4, 64) // vec4
+
+	// setup fbo holding a copy of the scene to read from while rendering the water
+	scenefbo := engine.MakeFBO(width, height)
+
+	return Water{
+		shader:      shader,
+		buffer:      &pointbuffer,
+		chunkbuffer: chunkbuffer,
+		chunks:      nil,
+		chunkcount:  0,
+		scenefbo:    &scenefbo,
+		sealevel:    sealevel,
+		width:       width,
+		height:      height,
+		time:        0.0,
+	}, nil
+}
+
+// Update collects the position and size of all submerged Chunks from the visible Chunks of the Terrain.
+func (water *Water) Update(chunks []Chunk) {
+	water.chunks = water.chunks[:0]
+	for _, chunk := range chunks {
+		if !chunk.submerged {
+			continue
+		}
+		size := chunk.aabb.Max.X() - chunk.aabb.Min.X()
+		water.chunks = append(water.chunks, chunk.pos.X(), chunk.pos.Z(), size, 0.0)
+	}
+	water.chunkcount = int32(len(water.chunks) / 4)
+
+	// early return to prevent error
+	if water.chunkcount == 0 {
+		return
+	}
+
+	// update chunks
+	if int(water.chunkcount) > water.chunkbuffer.Len() {
+		water.chunkbuffer.Resize(2 * int(water.chunkcount))
+	}
+	water.chunkbuffer.UploadArrayInRange(water.chunks, 0, int(water.chunkcount))
+}
+
+// Render draws the water plane into the fbo which has to contain the color and depth of the Terrain.
+// The cube map of the sky is used for reflections that leave the screen.
+func (water *Water) Render(M mgl32.Mat4, camera *engine.CameraFPS, fbo *engine.FBO, sky *Sky) {
+	if water.chunkcount == 0 {
+		return
+	}
+
+	// copy the scene to be able to read from it while rendering into the fbo
+	fbo.CopyToFBO(water.scenefbo, 0, 0, water.width, water.height)
+
+	fbo.Bind()
+	water.scenefbo.ColorTextures[0].Bind(0)
+	water.scenefbo.DepthTexture.Bind(1)
+	sky.tex.Bind(2)
+	water.chunkbuffer.Bind(0)
+
+	// render water
+	water.shader.Use()
+	water.shader.UpdateMat4("M", M)
+	water.shader.UpdateMat4("V", camera.GetView())
+	water.shader.UpdateMat4("P", camera.GetPerspective())
+	water.shader.UpdateVec3("cameraPos", camera.Pos)
+	water.shader.UpdateFloat32("seaLevel", water.sealevel)
+	water.shader.UpdateFloat32("zNear", camera.Near)
+	water.shader.UpdateFloat32("zFar", camera.Far)
+	water.shader.UpdateVec2("resolution", mgl32.Vec2{float32(water.width), float32(water.height)})
+	water.shader.UpdateFloat32("t", water.time)
+	water.shader.RenderInstanced(water.chunkcount)
+
+	water.scenefbo.ColorTextures[0].Unbind()
+	water.scenefbo.DepthTexture.Unbind()
+	sky.tex.Unbind()
+	water.chunkbuffer.Unbind()
+	fbo.Unbind()
+
+	// update time
+	water.time++
+}
+
+// Delete destroys the buffers and the FBO of the Water.
+func (water *Water) Delete() {
+	water.chunkbuffer.Delete()
+	water.scenefbo.Delete()
+	water.buffer.Delete()
+}
@@ -3,6 +3,7 @@ package scene
 
 import (
 	"math"
+	"math/rand"
 
 	"github.com/go-gl/mathgl/mgl32"
 
@@ -33,7 +34,8 @@ type Wind struct {
 // The bell-curve spread is calculated by radius/influence.
 // This means that higher values of influence yield a stronger contracted spread.
 // The cellsize has to equal the tilesize of the terrain.
-func MakeWind(shaderpath string, radius int, influence, cellsize float32) (Wind, error) {
+// The seed decides the phases of the idle movement of each cell, thus the same seed always yields the same wind.
+func MakeWind(shaderpath string, radius int, influence, cellsize float32, seed int64) (Wind, error) {
 	griddim := 2*radius + 1
 	fieldsize := griddim * griddim
 
@@ -45,9 +47,10 @@ func MakeWind(shaderpath string, radius int, influence, cellsize float32) (Wind,
 	velocityfield := engine.MakeSSBO(bytesize, fieldsize)
 	velocityfield.UploadValue([]float32{0, 0, 0, 0})
 
-	// create accelerationfield
+	// create accelerationfield, the last component holds the random phase of the idle movement
 	accelerationfield := engine.MakeSSBO(bytesize, fieldsize)
 	afielddata := make([]float32, fieldsize*valuecount)
+	random := rand.New(rand.NewSource(seed))
 	var max float32 = 0.0
 	for z := 0; z < griddim; z++ {
 		dz := 4 * float64(z-radius) / float64(radius) * float64(influence)
@@ -59,7 +62,7 @@ func MakeWind(shaderpath string, radius int, influence, cellsize float32) (Wind,
 			afielddata[idx] = float32(dx) * float32(e)
 			afielddata[idx+1] = float32(dz) * float32(e)
 			afielddata[idx+2] = 0.0
-			afielddata[idx+3] = 0.0
+			afielddata[idx+3] = random.Float32() * 2 * math.Pi
 			// calculate max magnitude of all acceleration vectors
 			max = mathutils.MaxF32(max, afielddata[idx])
 			max = mathutils.MaxF32(max, afielddata[idx+1])
@@ -149,3 +152,10 @@ func (wind *Wind) Update(pos, cameradelta mgl32.Vec3) {
 	// update time
 	wind.t++
 }
+
+// Delete destroys the velocity and acceleration fields and the compute shader of the Wind.
+func (wind *Wind) Delete() {
+	wind.shader.Delete()
+	wind.velocityfield.Delete()
+	wind.accelerationfield.Delete()
+}